@@ -15,6 +15,11 @@ import (
 var viperConfig *viper.Viper
 
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "sign" {
+		sign(os.Args[2:])
+		return
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to find working directory")
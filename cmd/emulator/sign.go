@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/signedurls"
+	"github.com/sirupsen/logrus"
+)
+
+// sign mints signed urls and cookies for the behaviors with trusted key groups
+func sign(args []string) {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: emulator sign -key private.pem -key-pair-id ID [flags] URL")
+		flags.PrintDefaults()
+	}
+	keyFile := flags.String("key", "", "the PEM encoded RSA or ECDSA private key")
+	keyPairID := flags.String("key-pair-id", "", "the id of the public key in the key group")
+	expires := flags.Duration("expires", time.Hour, "how long the signature is valid for")
+	starts := flags.Duration("starts", 0, "how long until the signature is valid, it makes the policy custom")
+	sourceIP := flags.String("ip", "", "the ip or CIDR range allowed to use the signature, it makes the policy custom")
+	resource := flags.String("resource", "", "the resource of the policy, which can use wildcards, it makes the policy custom")
+	cookies := flags.Bool("cookies", false, "print signed cookies instead of a signed url")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *keyFile == "" || *keyPairID == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	url := flags.Arg(0)
+
+	key, err := signedurls.LoadPrivateKey(*keyFile)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to load the private key")
+	}
+
+	now := time.Now()
+	canned := *starts == 0 && *sourceIP == "" && *resource == ""
+
+	policy := signedurls.CannedPolicy(url, now.Add(*expires))
+	if !canned {
+		if *resource == "" {
+			*resource = url
+		}
+
+		var startTime time.Time
+		if *starts != 0 {
+			startTime = now.Add(*starts)
+		}
+
+		policy, err = signedurls.CustomPolicy(*resource, now.Add(*expires), startTime, *sourceIP)
+		if err != nil {
+			logrus.WithError(err).Fatal("failed to generate the policy")
+		}
+	}
+
+	if !*cookies {
+		signedURL, err := signedurls.SignURL(url, policy, canned, *keyPairID, key)
+		if err != nil {
+			logrus.WithError(err).Fatal("failed to sign the url")
+		}
+		fmt.Println(signedURL)
+		return
+	}
+
+	signedCookies, err := signedurls.SignCookies(policy, canned, *keyPairID, key)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to sign the cookies")
+	}
+
+	values := []string{}
+	for name, value := range signedCookies {
+		values = append(values, name+"="+value)
+	}
+	sort.Strings(values)
+	fmt.Printf("Cookie: %s\n", strings.Join(values, "; "))
+}
@@ -0,0 +1,15 @@
+exports.handler = async (event) => {
+  return {
+    statusCode: 200,
+    headers: {
+      "content-type": "application/json",
+    },
+    cookies: ["visited=true"],
+    body: JSON.stringify({
+      method: event.requestContext.http.method,
+      path: event.rawPath,
+      query: event.queryStringParameters,
+      servedBy: event.headers["x-served-by"],
+    }),
+  }
+}
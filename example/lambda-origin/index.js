@@ -0,0 +1,12 @@
+exports.handler = async (event, context, callback) => {
+  const { request } = event.Records[0].cf
+
+  request.headers["x-served-by"] = [
+    {
+      key: "x-served-by",
+      value: "edwardofclt/cloudfront-lambda@edge-emulator",
+    },
+  ]
+
+  return callback(null, request)
+}
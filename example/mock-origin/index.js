@@ -0,0 +1,12 @@
+exports.handler = async (event, context, callback) => {
+  const { response } = event.Records[0].cf
+
+  response.headers["x-origin-status"] = [
+    {
+      key: "x-origin-status",
+      value: response.status,
+    },
+  ]
+
+  return callback(null, response)
+}
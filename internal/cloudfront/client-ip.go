@@ -0,0 +1,95 @@
+package cloudfront
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parseTrustedProxies parses the ips and CIDR ranges of the proxies the
+// emulator can sit behind, invalid entries are ignored
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				logrus.Errorf("bad configuration: invalid trusted proxy: %s", proxy)
+				continue
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logrus.WithError(err).Errorf("bad configuration: invalid trusted proxy: %s", proxy)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isTrustedProxy(trustedProxies []*net.IPNet, ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost is the ip of the connection without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// resolveClientIP returns the ip of the viewer. When the connection comes from
+// a trusted proxy the viewer is the last address of X-Forwarded-For that isn't
+// a trusted proxy, and the addresses the proxies appended are taken out of the
+// header so it looks like the viewer connected directly.
+func resolveClientIP(trustedProxies []*net.IPNet, r *http.Request) string {
+	clientIP := remoteHost(r)
+	if ip := net.ParseIP(clientIP); ip == nil || !isTrustedProxy(trustedProxies, ip) {
+		return clientIP
+	}
+
+	forwardedFor := []string{}
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(value, ",") {
+			forwardedFor = append(forwardedFor, strings.TrimSpace(address))
+		}
+	}
+
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwardedFor[i])
+		if ip == nil {
+			break
+		}
+
+		clientIP = ip.String()
+		forwardedFor = forwardedFor[:i]
+		if !isTrustedProxy(trustedProxies, ip) {
+			break
+		}
+	}
+
+	r.Header.Del("X-Forwarded-For")
+	if len(forwardedFor) > 0 {
+		r.Header.Set("X-Forwarded-For", strings.Join(forwardedFor, ", "))
+	}
+
+	return clientIP
+}
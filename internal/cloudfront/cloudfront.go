@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -22,6 +23,8 @@ import (
 	"time"
 
 	_ "github.com/davecgh/go-spew/spew"
+	"github.com/edwardofclt/cloudfront-emulator/internal/geoip"
+	"github.com/edwardofclt/cloudfront-emulator/internal/headercase"
 	"github.com/edwardofclt/cloudfront-emulator/internal/lambda"
 	originrequest "github.com/edwardofclt/cloudfront-emulator/internal/origin-request"
 	originresponse "github.com/edwardofclt/cloudfront-emulator/internal/origin-response"
@@ -49,10 +52,7 @@ func New(config *types.CloudfrontConfig) *CfServer {
 		addr = *config.Address
 	}
 
-	port := 443
-	if config.Port != nil {
-		port = *config.Port
-	}
+	port := listenPort(config)
 
 	eventHandlers := []Event{
 		{
@@ -73,22 +73,38 @@ func New(config *types.CloudfrontConfig) *CfServer {
 		},
 	}
 
+	handler := generateRoutes(config, eventHandlers)
 	cf := &CfServer{
 		Server: &http.Server{
-			Addr:    fmt.Sprintf("%s:%d", addr, port),
-			Handler: generateRoutes(config, eventHandlers),
+			Addr:        net.JoinHostPort(addr, strconv.Itoa(port)),
+			Handler:     headercase.Handler(handler),
+			ConnContext: headercase.ConnContext,
 		},
 		Wg:            &sync.WaitGroup{},
 		EventHandlers: eventHandlers,
 	}
 
-	if port == 443 {
+	cf.TLSServer = newTLSServer(config, addr, port, handler)
+	if port == 443 || cf.TLSServer != nil {
 		cf.PathToCerts = generateCertsForSSL(addr)
 	}
 
 	return cf
 }
 
+// newTLSServer returns the server of the https port, it's nil when the main
+// port serves HTTPS itself or there's no https port
+func newTLSServer(config *types.CloudfrontConfig, addr string, port int, handler http.Handler) *http.Server {
+	if !servesBothProtocols(config, port) {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:    net.JoinHostPort(addr, strconv.Itoa(httpsPort(config))),
+		Handler: handler,
+	}
+}
+
 func (cf *CfServer) Start() {
 	startServer(cf)
 }
@@ -100,6 +116,17 @@ func generateRoutes(config *types.CloudfrontConfig, eventHandlers []Event) *chi.
 		return config.Behaviors[i].Path > config.Behaviors[j].Path
 	})
 
+	trustedProxies := parseTrustedProxies(config.TrustedProxies)
+	cachedErrors := newErrorCache()
+	validateViewerProtocolPolicies(config)
+	validateMethods(config.Behaviors)
+	trustedKeys := loadTrustedKeys(config)
+	validateGeoRestriction(config.GeoRestriction)
+	webACL := loadWebACL(config)
+	if config.QuotaMode != "" && config.QuotaMode != types.QuotaModeStrict && config.QuotaMode != types.QuotaModeWarn {
+		logrus.Errorf("bad configuration: unknown quota mode %s, quotas are enforced strictly", config.QuotaMode)
+	}
+
 	for _, behaviorValue := range config.Behaviors {
 		// make a copy since behaviorValue is a pointer in the slice
 		behavior := behaviorValue
@@ -110,15 +137,43 @@ func generateRoutes(config *types.CloudfrontConfig, eventHandlers []Event) *chi.
 
 			origin, ok := config.OriginConfigs[behavior.Origin]
 			if !ok {
-				err := fmt.Errorf("bad configuration: behavior uses undefined origin: %s requestId: %s", behavior.Origin, requestId)
-				logrus.Error(err)
-				w.Write([]byte(err.Error()))
+				sendInternalError(w, config, requestId, "bad configuration", fmt.Errorf("behavior uses undefined origin: %s", behavior.Origin))
+				return
 			}
 
 			var finalResponse *types.CfResponse
+			var originBody io.ReadCloser
 			var err error
 
-			requestPayload := generateRequestBody(requestId, types.ViewerRequest, r)
+			// the error of the origin is cached under the request the origin
+			// triggers got, cacheHit means it came from the cache
+			var cacheKey string
+			var cacheable, cacheHit bool
+			errorPage := isErrorPageRequest(r)
+			if !errorPage && enforceViewerProtocolPolicy(w, r, config, behavior, requestId) {
+				return
+			}
+			if enforceAllowedMethods(w, r, config, behavior, requestId) {
+				return
+			}
+
+			// the web ACL, the geo restriction and the signature are checked in
+			// that order before any trigger runs
+			clientIP := resolveClientIP(trustedProxies, r)
+			geoProfile := resolveGeoProfile(config, r, clientIP)
+			if !errorPage && enforceWebACL(w, handlers, r, config, webACL, clientIP, geoProfile, requestId) {
+				return
+			}
+			if !errorPage && enforceGeoRestriction(w, handlers, r, config, geoProfile, requestId) {
+				return
+			}
+			if !errorPage && enforceSignedRequests(w, r, config, trustedKeys[behavior.Path], clientIP, requestId) {
+				return
+			}
+			requestPayload := generateRequestBody(requestId, types.ViewerRequest, r, clientIP)
+			if quotaExceeded(w, config, requestId, types.CheckRequestQuotas("", requestPayload)) {
+				return
+			}
 			responsePayload := &types.CfResponse{}
 			recordPayload := &types.RequestPayload{
 				Records: []types.Record{
@@ -138,6 +193,34 @@ func generateRoutes(config *types.CloudfrontConfig, eventHandlers []Event) *chi.
 
 			callbackContent := &types.CallbackResponse{}
 			for _, eventHandler := range eventHandlers {
+				// don't let the result of the previous handler leak into this one
+				*callbackContent = types.CallbackResponse{}
+
+				// only the origin triggers run when the distribution fetches a custom error page
+				if errorPage && (eventHandler.Name == types.ViewerRequest || eventHandler.Name == types.ViewerResponse) {
+					continue
+				}
+
+				// CloudFront asks the origin for the default root object when the root is requested
+				if eventHandler.Name == types.OriginRequest && config.DefaultRootObject != "" && requestPayload.URI == "/" {
+					requestPayload.URI = "/" + strings.TrimPrefix(config.DefaultRootObject, "/")
+				}
+
+				// a cached error of the origin skips the origin triggers like any cache hit
+				if eventHandler.Name == types.OriginRequest {
+					cacheKey, cacheable = errorCacheKey(behavior, requestPayload)
+					if cached, ok := cachedErrors.get(cacheKey); cacheable && ok {
+						finalResponse, cacheHit = cached, true
+						responseHeaders := types.CfHeaderArray{}
+						types.MergeHeaders(&responseHeaders, finalResponse.Headers)
+						responsePayload.Status = finalResponse.Status
+						responsePayload.Headers = &responseHeaders
+					}
+				}
+				if cacheHit && (eventHandler.Name == types.OriginRequest || eventHandler.Name == types.OriginResponse) {
+					continue
+				}
+
 				wg := &sync.WaitGroup{}
 				// In order to make the callback function work more like what (I think) the callback does
 				// within AWS, we're going to make it actually callback to a server endpoint with POST data.
@@ -145,37 +228,118 @@ func generateRoutes(config *types.CloudfrontConfig, eventHandlers []Event) *chi.
 				// actual response data separate.
 				wg.Add(1)
 
-				alreadyCalled := false
+				var callbackData []byte
+				once := &sync.Once{}
 				callback := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
 					data, err := io.ReadAll(r.Body)
 					if err != nil {
-						sendErrorResponse(w, "failed to parse callback content", err.Error())
+						logrus.WithError(err).WithField("requestId", requestId).Error("failed to read the callback content")
+					}
+
+					once.Do(func() {
+						// /done means the handler finished without responding
+						if r.URL.Path != "/done" {
+							callbackData = data
+						}
+						wg.Done()
+					})
+				}))
+				defer callback.Close()
+
+				// The origin is only exposed to handlers from the origin-request event onwards
+				if eventHandler.Name == types.OriginRequest {
+					// CloudFront only adds the headers describing the viewer after the viewer-request event
+					addViewerHeaders(requestPayload, behavior.OriginRequestPolicy, viewerHeaders(r, clientIP, geoProfile))
+
+					// the accept-encoding header of the cache key is what the origin gets
+					if behavior.Compress {
+						normalizeAcceptEncoding(requestPayload)
+					}
+
+					requestPayload.Origin = origins.NewCfOrigin(origin, r)
+					addOriginRequestHeaders(requestPayload, behavior.OriginRequestPolicy, requestId)
+					if err := types.CheckOriginCustomHeaders(*requestPayload.Origin.CustomHeaders()); err != nil {
+						sendInternalError(w, config, requestId, fmt.Sprintf("bad configuration: origin %s has invalid custom headers", behavior.Origin), err)
 						return
 					}
 
-					err = json.Unmarshal(data, callbackContent)
+					signatureHeaders, err := origins.SignatureHeaders(&origins.OriginRequestConfig{
+						HTTPRequest:      r,
+						CfRequest:        *recordPayload,
+						Origin:           origin,
+						WorkingDirectory: config.WorkingDirectory,
+					})
 					if err != nil {
-						sendErrorResponse(w, "failed to unmarshal callback content", err.Error())
+						sendInternalError(w, config, requestId, "failed to sign the origin request", err)
 						return
 					}
-					if !alreadyCalled {
-						alreadyCalled = true
-						wg.Done()
-					}
-				}))
-				defer callback.Close()
+					types.MergeHeaders(requestPayload.Headers, &signatureHeaders)
+				}
 
 				// We do this check because it's the origin is request immediately before OriginResponse
 				if eventHandler.Name == types.OriginResponse {
-					finalResponse, err = origins.Request(&origins.OriginRequestConfig{
-						HTTPRequest: r,
-						CfRequest:   *recordPayload,
-						Origin:      origin,
+					finalResponse, originBody, err = origins.Request(&origins.OriginRequestConfig{
+						HTTPRequest:      r,
+						CfRequest:        *recordPayload,
+						Origin:           origin,
+						WorkingDirectory: config.WorkingDirectory,
 					})
 					if err != nil {
-						sendErrorResponse(w, "failed to make origin request", err.Error())
+						sendOriginError(w, handlers, r, config, requestId, err)
 						return
 					}
+					defer originBody.Close()
+
+					// handlers only get to see the status and headers of the origin response
+					responseHeaders := types.CfHeaderArray{}
+					types.MergeHeaders(&responseHeaders, finalResponse.Headers)
+					responsePayload.Status = finalResponse.Status
+					responsePayload.Headers = &responseHeaders
+				}
+
+				// CloudFront replaces the errors of the origin with the custom error pages
+				// before the viewer-response event
+				if eventHandler.Name == types.ViewerResponse && finalResponse != nil {
+					if customError, ok := customErrorResponse(config, *finalResponse.Status); ok {
+						if finalResponse.Body == nil {
+							data, err := io.ReadAll(originBody)
+							if err != nil {
+								sendOriginError(w, handlers, r, config, requestId, err)
+								return
+							}
+							body := string(data)
+							finalResponse.Body = &body
+						}
+
+						if cacheable && !cacheHit {
+							cachedErrors.set(cacheKey, *finalResponse, customError.CachingTTL())
+						}
+
+						if customError.ResponsePagePath != "" {
+							page := fetchErrorPage(handlers, r, customError.ResponsePagePath)
+							if status, _ := strconv.Atoi(*page.Status); status >= 400 {
+								logrus.WithField("requestId", requestId).Warnf("failed to fetch the custom error page %s: %s", customError.ResponsePagePath, *page.Status)
+							} else {
+								status := *finalResponse.Status
+								if customError.ResponseCode != 0 {
+									status = strconv.Itoa(customError.ResponseCode)
+								}
+								page.Status = &status
+								finalResponse = page
+
+								responseHeaders := types.CfHeaderArray{}
+								types.MergeHeaders(&responseHeaders, finalResponse.Headers)
+								responsePayload.Status = finalResponse.Status
+								responsePayload.Headers = &responseHeaders
+							}
+						}
+					}
+				}
+
+				// CloudFront adds itself to the via header of the response it sends to the viewer
+				if eventHandler.Name == types.ViewerResponse && finalResponse != nil {
+					appendHeader(*finalResponse.Headers, "Via", viaHeader("1.1", requestId))
+					(*responsePayload.Headers)["via"] = (*finalResponse.Headers)["via"]
 				}
 
 				// If the configuration isn't configured for this event type, go on to the next event type
@@ -185,10 +349,17 @@ func generateRoutes(config *types.CloudfrontConfig, eventHandlers []Event) *chi.
 				}
 				recordPayload.Records[0].Cf.Config.EventType = eventHandler.Name
 
+				isRequestEvent := eventHandler.Name == types.ViewerRequest || eventHandler.Name == types.OriginRequest
+				if isRequestEvent && handlerContext.IncludeBody {
+					if err := includeRequestBody(eventHandler.Name, r, requestPayload); err != nil {
+						sendInternalError(w, config, requestId, "failed to include the request body", err)
+						return
+					}
+				}
+
 				payload, err := recordPayload.EncodeJSON()
 				if err != nil {
-					logrus.WithError(err).Fatal("something went wrong marshaling the request")
-					sendErrorResponse(w, "failed to generate status", err.Error())
+					sendInternalError(w, config, requestId, "failed to encode the event", err)
 					return
 				}
 
@@ -196,40 +367,122 @@ func generateRoutes(config *types.CloudfrontConfig, eventHandlers []Event) *chi.
 					Callback:         callback,
 					Payload:          payload,
 					WorkingDirectory: config.WorkingDirectory,
+					EventType:        eventHandler.Name,
 					Context:          handlerContext,
 					Waitgroup:        wg,
 				})
 				if err != nil {
-					sendErrorResponse(w, fmt.Sprintf("failed to execute the lambda\n%s", resp), err.Error())
+					sendLambdaExecutionError(w, handlers, r, config, requestId, eventHandler.Name, err, resp)
+					return
+				}
+
+				// the handler exited without calling back
+				once.Do(func() {
+					err = fmt.Errorf("the function exited without a response")
+					wg.Done()
+				})
+				if err != nil {
+					sendLambdaExecutionError(w, handlers, r, config, requestId, eventHandler.Name, err, resp)
 					return
 				}
 
 				wg.Wait()
 
-				config := types.CloudfrontEventInput{
+				if callbackData != nil {
+					if err := types.ValidateCallback(eventHandler.Name, callbackData); err != nil {
+						sendLambdaValidationError(w, config, requestId, err)
+						return
+					}
+
+					if err := json.Unmarshal(callbackData, callbackContent); err != nil {
+						sendLambdaValidationError(w, config, requestId, err)
+						return
+					}
+				}
+
+				eventInput := types.CloudfrontEventInput{
 					CallbackResponse: *callbackContent,
+					Returned:         callbackData != nil,
 					CfRequest:        requestPayload,
 					CfResponse:       responsePayload,
 					FinalResponse:    finalResponse,
 				}
 
-				err = eventHandler.Handler.Execute(config)
+				err = eventHandler.Handler.Execute(eventInput)
 				if err != nil {
-					sendErrorResponse(w, "failed to execute handler actions", err.Error())
+					sendLambdaValidationError(w, config, requestId, err)
 					return
 				}
 
+				if isRequestEvent {
+					if err := applyRequestBody(r, requestPayload); err != nil {
+						sendLambdaValidationError(w, config, requestId, err)
+						return
+					}
+
+					if callbackContent.Status == nil && quotaExceeded(w, config, requestId, types.CheckRequestQuotas(eventHandler.Name, requestPayload)) {
+						return
+					}
+				}
+
+				var generatedBody []byte
+				if callbackContent.Body != nil {
+					generatedBody, err = types.DecodeGeneratedBody(*callbackContent.Body, callbackContent.BodyEncoding)
+					if err != nil {
+						sendLambdaValidationError(w, config, requestId, err)
+						return
+					}
+				}
+
+				isResponseEvent := eventHandler.Name == types.OriginResponse || eventHandler.Name == types.ViewerResponse
+
+				// the size of generated responses is measured the way the handler returned them
+				if callbackContent.Status != nil || (isResponseEvent && callbackContent.Body != nil) {
+					headers := callbackContent.Headers
+					if isResponseEvent {
+						headers = finalResponse.Headers
+					}
+
+					var body []byte
+					if callbackContent.Body != nil {
+						body = []byte(*callbackContent.Body)
+					}
+
+					if quotaExceeded(w, config, requestId, types.CheckResponseQuota(eventHandler.Name, headers, body)) {
+						return
+					}
+				}
+
+				if isResponseEvent {
+					if callbackContent.Status != nil {
+						finalResponse.Status = callbackContent.Status
+					}
+
+					// the origin body is only replaced when the handler returns a body of its own
+					if callbackContent.Body != nil {
+						body := string(generatedBody)
+						finalResponse.Body = &body
+						delete(*finalResponse.Headers, "content-length")
+					}
+
+					// like the origin body, a generated body isn't exposed to the next handler
+					responsePayload.Body = nil
+					continue
+				}
+
 				if callbackContent.Status != nil {
 					statusVal, err := strconv.Atoi(*callbackContent.Status)
 					if err != nil {
-						sendErrorResponse(w, fmt.Sprintf("invalid status code: %s", *callbackContent.Status), err.Error())
+						sendLambdaValidationError(w, config, requestId, fmt.Errorf("invalid status code: %s", *callbackContent.Status))
 						return
 					}
 
-					writeRequestHeaders(w, *callbackContent.Headers)
+					if callbackContent.Headers != nil {
+						writeRequestHeaders(w, *callbackContent.Headers)
+					}
 					w.WriteHeader(statusVal)
-					if callbackContent.Body != nil {
-						w.Write([]byte(*callbackContent.Body))
+					if callbackContent.Body != nil && r.Method != http.MethodHead {
+						w.Write(generatedBody)
 					}
 					return
 				}
@@ -240,16 +493,39 @@ func generateRoutes(config *types.CloudfrontConfig, eventHandlers []Event) *chi.
 
 			statusVal, err := strconv.Atoi(*finalResponse.Status)
 			if err != nil {
-				sendErrorResponse(w, fmt.Sprintf("invalid status code: %s", *finalResponse.Status), err.Error())
+				sendLambdaValidationError(w, config, requestId, fmt.Errorf("invalid status code: %s", *finalResponse.Status))
 				return
 			}
 
-			for key, val := range *finalResponse.Headers {
-				w.Header().Add(key, val[0].Value)
+			// the length of a generated body is set when it's written, a later
+			// handler could have put back the one of the origin
+			if finalResponse.Body != nil {
+				delete(*finalResponse.Headers, "content-length")
+			}
+
+			// CloudFront compresses the response after the viewer-response event,
+			// custom error pages are compressed with the response they replace
+			encoding := ""
+			if !errorPage {
+				encoding = compressionEncoding(behavior, r, statusVal, *finalResponse)
 			}
+			if encoding != "" {
+				setCompressedHeaders(*finalResponse.Headers, encoding)
+			}
+
+			writeResponseHeaders(w, *finalResponse)
 			w.WriteHeader(statusVal)
+			// responses to HEAD requests never have a body
+			if r.Method == http.MethodHead {
+				return
+			}
+
+			body := originBody
 			if finalResponse.Body != nil {
-				w.Write([]byte(*finalResponse.Body))
+				body = io.NopCloser(strings.NewReader(*finalResponse.Body))
+			}
+			if err := writeBody(w, body, encoding); err != nil {
+				logrus.WithError(err).WithField("requestId", requestId).Error("failed to stream the origin response")
 			}
 		})
 	}
@@ -288,7 +564,9 @@ func mergeRequestResponseWithRequestPayload(requestPayload types.RequestPayload,
 }
 
 type CfServer struct {
-	Server        *http.Server
+	Server *http.Server
+	// TLSServer serves HTTPS next to Server when Server is plain HTTP
+	TLSServer     *http.Server
 	Handler       http.Handler
 	Wg            *sync.WaitGroup
 	PathToCerts   string
@@ -300,47 +578,75 @@ func (cf *CfServer) Refresh(config *types.CloudfrontConfig) {
 	if err := cf.Server.Shutdown(ctx); err != nil {
 		logrus.WithError(err).Fatal("failed to shutdown server")
 	}
+	if cf.TLSServer != nil {
+		if err := cf.TLSServer.Shutdown(ctx); err != nil {
+			logrus.WithError(err).Fatal("failed to shutdown https server")
+		}
+	}
 	logrus.Info("waiting for server to shutdown")
 
 	// make sure the
 	cf.Wg.Wait()
 
+	// the databases may have changed with the configuration
+	geoip.Close()
+
 	// decalre a new server
+	handler := generateRoutes(config, cf.EventHandlers)
 	cf.Server = &http.Server{
-		Addr:    cf.Server.Addr,
-		Handler: generateRoutes(config, cf.EventHandlers),
+		Addr:        cf.Server.Addr,
+		Handler:     headercase.Handler(handler),
+		ConnContext: headercase.ConnContext,
+	}
+
+	// the https port can be added or removed, the main port stays the same
+	addr, portString, _ := net.SplitHostPort(cf.Server.Addr)
+	port, _ := strconv.Atoi(portString)
+	cf.TLSServer = newTLSServer(config, addr, port, handler)
+	if cf.TLSServer != nil && cf.PathToCerts == "" {
+		cf.PathToCerts = generateCertsForSSL(addr)
 	}
 
 	startServer(cf)
 }
 
 func startServer(cf *CfServer) {
-	if strings.Split(cf.Server.Addr, ":")[1] == "443" {
-		go func(cf *CfServer) {
-			cf.Wg.Add(1)
+	_, port, _ := net.SplitHostPort(cf.Server.Addr)
+	serve(cf, cf.Server, port == "443")
+	if cf.TLSServer != nil {
+		serve(cf, cf.TLSServer, true)
+	}
+	logrus.Info("Server Started 🚀")
+}
+
+func serve(cf *CfServer, server *http.Server, useTLS bool) {
+	if useTLS {
+		cf.Wg.Add(1)
+		go func() {
 			defer cf.Wg.Done()
-			if err := cf.Server.ListenAndServeTLS(fmt.Sprintf("%s/cert.pem", cf.PathToCerts), fmt.Sprintf("%s/key.pem", cf.PathToCerts)); err != nil && err != http.ErrServerClosed {
+			if err := server.ListenAndServeTLS(fmt.Sprintf("%s/cert.pem", cf.PathToCerts), fmt.Sprintf("%s/key.pem", cf.PathToCerts)); err != nil && err != http.ErrServerClosed {
 				logrus.WithError(err).Error("shutting down https server")
 			}
-		}(cf)
+		}()
+		return
+	}
 
-		logrus.Info("Server Started 🚀")
-	} else {
-		go func(cf *CfServer) {
-			cf.Wg.Add(1)
-			defer cf.Wg.Done()
-			if err := cf.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logrus.WithError(err).Error("shutting down http server")
-			}
-		}(cf)
-		logrus.Info("Server Started 🚀")
+	// plain HTTP/1.x connections record the case of the header names the
+	// viewer sends, TLS connections are negotiated to HTTP/2 where they are
+	// always lowercase
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logrus.WithError(err).Error("failed to start the http server")
+		return
 	}
-}
 
-func sendErrorResponse(w http.ResponseWriter, content, payload string) {
-	w.WriteHeader(502)
-	w.Header().Add("content-type", "text/html")
-	fmt.Fprintf(w, `<html><body><h1>502 Error</h1><hr /><p><em>If you're seeing this it means something went wrong executing the logic in your lambda... More context can be found below:</em></p><hr /><pre>%s</pre><hr /><pre>%s</pre></body></html>`, content, payload)
+	cf.Wg.Add(1)
+	go func() {
+		defer cf.Wg.Done()
+		if err := server.Serve(headercase.Listener{Listener: listener}); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Error("shutting down http server")
+		}
+	}()
 }
 
 func generateCertsForSSL(host string) string {
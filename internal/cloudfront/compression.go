@@ -0,0 +1,220 @@
+package cloudfront
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+)
+
+const (
+	// MinCompressedSize is the smallest response CloudFront compresses
+	MinCompressedSize = 1000
+	// MaxCompressedSize is the largest response CloudFront compresses
+	MaxCompressedSize = 10000000
+
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// compressedContentTypes are the content types CloudFront compresses
+var compressedContentTypes = map[string]struct{}{
+	"application/dash+xml":               {},
+	"application/eot":                    {},
+	"application/font":                   {},
+	"application/font-sfnt":              {},
+	"application/javascript":             {},
+	"application/json":                   {},
+	"application/opentype":               {},
+	"application/otf":                    {},
+	"application/pdf":                    {},
+	"application/pkcs7-mime":             {},
+	"application/protobuf":               {},
+	"application/rss+xml":                {},
+	"application/truetype":               {},
+	"application/ttf":                    {},
+	"application/vnd.apple.mpegurl":      {},
+	"application/vnd.mapbox-vector-tile": {},
+	"application/vnd.ms-fontobject":      {},
+	"application/wasm":                   {},
+	"application/xhtml+xml":              {},
+	"application/xml":                    {},
+	"application/x-font-opentype":        {},
+	"application/x-font-truetype":        {},
+	"application/x-font-ttf":             {},
+	"application/x-httpd-cgi":            {},
+	"application/x-javascript":           {},
+	"application/x-mpegurl":              {},
+	"application/x-opentype":             {},
+	"application/x-otf":                  {},
+	"application/x-perl":                 {},
+	"application/x-ttf":                  {},
+	"font/eot":                           {},
+	"font/opentype":                      {},
+	"font/otf":                           {},
+	"font/ttf":                           {},
+	"image/svg+xml":                      {},
+	"text/css":                           {},
+	"text/csv":                           {},
+	"text/html":                          {},
+	"text/javascript":                    {},
+	"text/js":                            {},
+	"text/plain":                         {},
+	"text/richtext":                      {},
+	"text/tab-separated-values":          {},
+	"text/xml":                           {},
+	"text/x-component":                   {},
+	"text/x-java-source":                 {},
+	"text/x-script":                      {},
+}
+
+// acceptedEncodings returns the encodings CloudFront compresses with that the
+// Accept-Encoding header accepts, Brotli first
+func acceptedEncodings(acceptEncoding string) []string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		// an encoding with a quality of 0 isn't acceptable
+		acceptable := true
+		for _, param := range fields[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if q, err := strconv.ParseFloat(value, 64); strings.EqualFold(name, "q") && err == nil && q == 0 {
+				acceptable = false
+			}
+		}
+		accepted[encoding] = accepted[encoding] || acceptable
+	}
+
+	encodings := []string{}
+	for _, encoding := range []string{EncodingBrotli, EncodingGzip} {
+		if accepted[encoding] {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// normalizeAcceptEncoding rewrites the Accept-Encoding header of the request
+// the way CloudFront does for the cache key and the origin request, only br
+// and gzip are kept
+func normalizeAcceptEncoding(request *types.CfRequest) {
+	if request.Headers == nil {
+		return
+	}
+
+	headers := *request.Headers
+	values := []string{}
+	for _, header := range headers["accept-encoding"] {
+		values = append(values, header.Value)
+	}
+
+	encodings := acceptedEncodings(strings.Join(values, ","))
+	if len(encodings) == 0 {
+		delete(headers, "accept-encoding")
+		return
+	}
+
+	headers["accept-encoding"] = []types.CfHeader{
+		{
+			Key:   "Accept-Encoding",
+			Value: strings.Join(encodings, ","),
+		},
+	}
+}
+
+// compressionEncoding picks the encoding the response is compressed with, it's
+// empty when CloudFront wouldn't compress it
+func compressionEncoding(behavior types.Behavior, r *http.Request, status int, response types.CfResponse) string {
+	if !behavior.Compress || response.Headers == nil {
+		return ""
+	}
+
+	if status != http.StatusOK && status != http.StatusForbidden && status != http.StatusNotFound {
+		return ""
+	}
+
+	headers := *response.Headers
+	if len(headers["content-encoding"]) > 0 {
+		return ""
+	}
+
+	if len(headers["content-type"]) == 0 {
+		return ""
+	}
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(headers["content-type"][0].Value, ";")[0]))
+	if _, ok := compressedContentTypes[contentType]; !ok {
+		return ""
+	}
+
+	// the size of streamed responses comes from the origin, CloudFront doesn't
+	// compress them when it doesn't say
+	size := -1
+	if response.Body != nil {
+		size = len(*response.Body)
+	} else if len(headers["content-length"]) > 0 {
+		if length, err := strconv.Atoi(headers["content-length"][0].Value); err == nil {
+			size = length
+		}
+	}
+	if size < MinCompressedSize || size > MaxCompressedSize {
+		return ""
+	}
+
+	encodings := acceptedEncodings(strings.Join(r.Header.Values("Accept-Encoding"), ","))
+	if len(encodings) == 0 {
+		return ""
+	}
+	return encodings[0]
+}
+
+// setCompressedHeaders describes the compressed body in the headers of the
+// response
+func setCompressedHeaders(headers types.CfHeaderArray, encoding string) {
+	delete(headers, "content-length")
+	headers["content-encoding"] = []types.CfHeader{{Key: "Content-Encoding", Value: encoding}}
+
+	vary := false
+	for _, header := range headers["vary"] {
+		for _, value := range strings.Split(header.Value, ",") {
+			value = strings.TrimSpace(value)
+			vary = vary || value == "*" || strings.EqualFold(value, "Accept-Encoding")
+		}
+	}
+	if !vary {
+		appendHeader(headers, "Vary", "Accept-Encoding")
+	}
+
+	// the compressed body isn't byte for byte the same anymore
+	for i, header := range headers["etag"] {
+		if !strings.HasPrefix(header.Value, "W/") {
+			headers["etag"][i].Value = "W/" + header.Value
+		}
+	}
+}
+
+// writeBody copies the body to the viewer, compressed with the encoding when
+// it's set
+func writeBody(w io.Writer, body io.Reader, encoding string) error {
+	var compressor io.WriteCloser
+	switch encoding {
+	case EncodingBrotli:
+		compressor = brotli.NewWriter(w)
+	case EncodingGzip:
+		compressor = gzip.NewWriter(w)
+	default:
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	if _, err := io.Copy(compressor, body); err != nil {
+		compressor.Close()
+		return err
+	}
+	return compressor.Close()
+}
@@ -0,0 +1,205 @@
+package cloudfront
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type errorPageKey struct{}
+
+// isErrorPageRequest reports whether the request was made by the distribution
+// to fetch a custom error page
+func isErrorPageRequest(r *http.Request) bool {
+	errorPage, _ := r.Context().Value(errorPageKey{}).(bool)
+	return errorPage
+}
+
+// customErrorResponse returns the custom error response configured for the
+// status of the origin
+func customErrorResponse(config *types.CloudfrontConfig, status string) (types.CustomErrorResponse, bool) {
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 400 {
+		return types.CustomErrorResponse{}, false
+	}
+
+	for _, customError := range config.CustomErrorResponses {
+		if customError.ErrorCode == code {
+			return customError, true
+		}
+	}
+	return types.CustomErrorResponse{}, false
+}
+
+// fetchErrorPage requests the custom error page through the distribution the
+// way CloudFront does, only the origin triggers of the behavior matching the
+// page run
+func fetchErrorPage(router http.Handler, r *http.Request, path string) *types.CfResponse {
+	// the route context of the viewer request would be reused by the router
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, nil)
+	ctx = context.WithValue(ctx, errorPageKey{}, true)
+
+	page := r.Clone(ctx)
+	page.Method = http.MethodGet
+	page.URL.Path = path
+	page.URL.RawPath = ""
+	page.URL.RawQuery = ""
+	page.RequestURI = path
+	page.Body = http.NoBody
+	page.ContentLength = 0
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, page)
+
+	status := strconv.Itoa(recorder.Code)
+	body := recorder.Body.String()
+	response := &types.CfResponse{
+		BaseConfig: types.BaseConfig{
+			Status:  &status,
+			Headers: &types.CfHeaderArray{},
+			Body:    &body,
+		},
+	}
+
+	headers := *response.Headers
+	for key, values := range recorder.Header() {
+		name := strings.ToLower(key)
+		// the page gets the request id of the viewer request
+		if name == "x-lambda-emulator-requestid" {
+			continue
+		}
+
+		for _, value := range values {
+			headers[name] = append(headers[name], types.CfHeader{
+				Key:   key,
+				Value: value,
+			})
+		}
+	}
+
+	return response
+}
+
+type cachedError struct {
+	response types.CfResponse
+	expires  time.Time
+}
+
+// errorCache keeps the errors of the origins for the error caching TTL of their
+// custom error response
+type errorCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedError
+}
+
+func newErrorCache() *errorCache {
+	return &errorCache{entries: map[string]cachedError{}}
+}
+
+// errorCacheKey identifies the object the request is for, only the methods the
+// behavior caches are cached
+func errorCacheKey(behavior types.Behavior, request *types.CfRequest) (string, bool) {
+	if !behavior.Caches(request.Method) {
+		return "", false
+	}
+	key := request.Method + " " + behavior.Path + " " + request.URI + "?" + request.QueryString
+
+	// compressed responses are cached per encoding
+	if behavior.Compress && request.Headers != nil {
+		values := []string{}
+		for _, header := range (*request.Headers)["accept-encoding"] {
+			values = append(values, header.Value)
+		}
+		key += " " + strings.Join(acceptedEncodings(strings.Join(values, ",")), ",")
+	}
+	return key, true
+}
+
+func (c *errorCache) get(key string) (*types.CfResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return copyResponse(entry.response), true
+}
+
+func (c *errorCache) set(key string, response types.CfResponse, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the entries that are never requested again would be kept forever
+	now := time.Now()
+	for cachedKey, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, cachedKey)
+		}
+	}
+
+	c.entries[key] = cachedError{response: *copyResponse(response), expires: now.Add(ttl)}
+}
+
+// copyResponse copies the headers of the response, handlers can change them
+func copyResponse(response types.CfResponse) *types.CfResponse {
+	headers := types.CfHeaderArray{}
+	if response.Headers != nil {
+		for name, values := range *response.Headers {
+			headers[name] = append([]types.CfHeader{}, values...)
+		}
+	}
+	response.Headers = &headers
+	return &response
+}
+
+// sendCustomErrorPage responds with the custom error page configured for an
+// error CloudFront generates itself. It reports whether there was one.
+func sendCustomErrorPage(w http.ResponseWriter, router http.Handler, r *http.Request, config *types.CloudfrontConfig, status int, requestId uuid.UUID) bool {
+	// the failures of the error pages themselves aren't replaced
+	if isErrorPageRequest(r) {
+		return false
+	}
+
+	customError, ok := customErrorResponse(config, strconv.Itoa(status))
+	if !ok || customError.ResponsePagePath == "" {
+		return false
+	}
+
+	page := fetchErrorPage(router, r, customError.ResponsePagePath)
+	if pageStatus, _ := strconv.Atoi(*page.Status); pageStatus >= 400 {
+		logrus.WithField("requestId", requestId).Warnf("failed to fetch the custom error page %s: %s", customError.ResponsePagePath, *page.Status)
+		return false
+	}
+
+	if customError.ResponseCode != 0 {
+		status = customError.ResponseCode
+	}
+
+	delete(*page.Headers, "content-length")
+	writeResponseHeaders(w, *page)
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(*page.Body))
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package cloudfront
+
+import (
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/lambda"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the error types CloudFront reports in the x-cache header
+const (
+	ErrorTypeError            = "Error"
+	ErrorTypeLambdaValidation = "LambdaValidationError"
+	ErrorTypeLambdaExecution  = "LambdaExecutionError"
+	ErrorTypeLambdaLimit      = "LambdaLimitExceeded"
+)
+
+const cloudfrontErrorPage = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">
+<HTML><HEAD><META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=iso-8859-1">
+<TITLE>ERROR: The request could not be satisfied</TITLE>
+</HEAD><BODY>
+<H1>%d ERROR</H1>
+<H2>The request could not be satisfied.</H2>
+<HR noshade size="1px">
+%s
+<BR clear="all">%s
+<HR noshade size="1px">
+<PRE>
+Generated by cloudfront (CloudFront)
+Request ID: %s
+</PRE>
+<ADDRESS>
+</ADDRESS>
+</BODY></HTML>`
+
+// cloudfrontError is a failure the way CloudFront reports it to the viewer.
+// Details are only shown to the viewer in verbose mode, they're always logged.
+type cloudfrontError struct {
+	Status  int
+	Type    string
+	Message string
+	Details string
+}
+
+func (e cloudfrontError) log(requestId uuid.UUID) {
+	logger := logrus.WithField("requestId", requestId).WithField("errorType", e.Type)
+	if e.Details != "" {
+		logger = logger.WithField("details", e.Details)
+	}
+	logger.Error(e.Message)
+}
+
+func (e cloudfrontError) send(w http.ResponseWriter, config *types.CloudfrontConfig, requestId uuid.UUID) {
+	e.log(requestId)
+
+	details := ""
+	if config.Verbose && e.Details != "" {
+		details = fmt.Sprintf("\n<PRE>%s</PRE>", html.EscapeString(e.Details))
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Server", "CloudFront")
+	w.Header().Set("X-Cache", fmt.Sprintf("%s from cloudfront", e.Type))
+	w.WriteHeader(e.Status)
+	fmt.Fprintf(w, cloudfrontErrorPage, e.Status, html.EscapeString(e.Message), details, requestId)
+}
+
+// sendOrCustomPage responds with the custom error page configured for the
+// status of the error, or with the error page of CloudFront when there's none
+func (e cloudfrontError) sendOrCustomPage(w http.ResponseWriter, router http.Handler, r *http.Request, config *types.CloudfrontConfig, requestId uuid.UUID) {
+	if sendCustomErrorPage(w, router, r, config, e.Status, requestId) {
+		e.log(requestId)
+		return
+	}
+	e.send(w, config, requestId)
+}
+
+// sendLambdaValidationError responds the way CloudFront does when a handler
+// returns something invalid, the reason is part of the page like it is on
+// CloudFront
+func sendLambdaValidationError(w http.ResponseWriter, config *types.CloudfrontConfig, requestId uuid.UUID, err error) {
+	cloudfrontError{
+		Status:  http.StatusBadGateway,
+		Type:    ErrorTypeLambdaValidation,
+		Message: fmt.Sprintf("The Lambda function result failed validation: %s", err),
+	}.send(w, config, requestId)
+}
+
+// sendLambdaExecutionError responds the way CloudFront does when a handler
+// crashes, times out or is throttled, with the custom error page for 503s when
+// there's one
+func sendLambdaExecutionError(w http.ResponseWriter, router http.Handler, r *http.Request, config *types.CloudfrontConfig, requestId uuid.UUID, eventType types.EventType, err error, output []byte) {
+	e := cloudfrontError{
+		Status:  http.StatusServiceUnavailable,
+		Type:    ErrorTypeLambdaExecution,
+		Message: "The Lambda function associated with the CloudFront distribution returned an error.",
+		Details: fmt.Sprintf("%s: %s\n%s", eventType, err, output),
+	}
+
+	switch errors.Cause(err) {
+	case lambda.ErrTimeout:
+		e.Message = "The Lambda function associated with the CloudFront distribution timed out."
+	case lambda.ErrThrottled:
+		e.Type = ErrorTypeLambdaLimit
+		e.Message = "The Lambda function associated with the CloudFront distribution was throttled."
+	}
+
+	e.sendOrCustomPage(w, router, r, config, requestId)
+}
+
+// sendOriginError responds the way CloudFront does when the origin can't be
+// reached, with the custom error page for the status when there's one
+func sendOriginError(w http.ResponseWriter, router http.Handler, r *http.Request, config *types.CloudfrontConfig, requestId uuid.UUID, err error) {
+	e := cloudfrontError{
+		Status:  http.StatusBadGateway,
+		Type:    ErrorTypeError,
+		Message: "CloudFront wasn't able to connect to the origin.",
+		Details: err.Error(),
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		e.Status = http.StatusGatewayTimeout
+		e.Message = "CloudFront attempted to establish a connection with the origin, but either the attempt failed or the origin closed the connection."
+	}
+
+	e.sendOrCustomPage(w, router, r, config, requestId)
+}
+
+// sendInternalError responds to failures that aren't caused by the handlers or
+// the origin, like a bad configuration
+func sendInternalError(w http.ResponseWriter, config *types.CloudfrontConfig, requestId uuid.UUID, message string, err error) {
+	cloudfrontError{
+		Status:  http.StatusInternalServerError,
+		Type:    ErrorTypeError,
+		Message: "CloudFront encountered an internal error.",
+		Details: fmt.Sprintf("%s: %s", message, err),
+	}.send(w, config, requestId)
+}
+
+// quotaExceeded responds with the error CloudFront returns when a quota is
+// exceeded, in warn mode the error is only logged. It reports whether the
+// request was ended.
+func quotaExceeded(w http.ResponseWriter, config *types.CloudfrontConfig, requestId uuid.UUID, err error) bool {
+	quotaErr, ok := err.(*types.QuotaError)
+	if !ok {
+		return false
+	}
+
+	if config.QuotaMode == types.QuotaModeWarn {
+		logrus.WithField("requestId", requestId).Warn(quotaErr)
+		return false
+	}
+
+	if quotaErr.Status == http.StatusBadGateway {
+		sendLambdaValidationError(w, config, requestId, quotaErr)
+		return true
+	}
+
+	cloudfrontError{
+		Status:  quotaErr.Status,
+		Type:    ErrorTypeError,
+		Message: fmt.Sprintf("Bad request: %s", quotaErr),
+	}.send(w, config, requestId)
+	return true
+}
@@ -0,0 +1,40 @@
+package cloudfront
+
+import (
+	"net/http"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// validateGeoRestriction logs an unknown restriction type, nobody is blocked
+// when it happens
+func validateGeoRestriction(restriction types.GeoRestriction) {
+	switch restriction.RestrictionType {
+	case "", types.GeoRestrictionNone, types.GeoRestrictionWhitelist, types.GeoRestrictionBlacklist:
+	default:
+		logrus.Errorf("bad configuration: unknown geo restriction type %s, nobody is blocked", restriction.RestrictionType)
+	}
+}
+
+// enforceGeoRestriction blocks the viewers from the countries the distribution
+// doesn't serve, with the custom error page for 403s when there's one. It
+// reports whether the request was ended.
+func enforceGeoRestriction(w http.ResponseWriter, router http.Handler, r *http.Request, config *types.CloudfrontConfig, geo types.GeoProfile, requestId uuid.UUID) bool {
+	if !config.GeoRestriction.Blocks(geo.Country) {
+		return false
+	}
+
+	logrus.WithField("requestId", requestId).Warnf("blocked a viewer from %s", geo.Country)
+	if sendCustomErrorPage(w, router, r, config, http.StatusForbidden, requestId) {
+		return true
+	}
+
+	cloudfrontError{
+		Status:  http.StatusForbidden,
+		Type:    ErrorTypeError,
+		Message: "The Amazon CloudFront distribution is configured to block access from your country.",
+	}.send(w, config, requestId)
+	return true
+}
@@ -0,0 +1,65 @@
+package cloudfront
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// the combinations of methods CloudFront lets behaviors allow and cache
+var (
+	allowedMethodSets = []string{"GET,HEAD", "GET,HEAD,OPTIONS", "DELETE,GET,HEAD,OPTIONS,PATCH,POST,PUT"}
+	cachedMethodSets  = []string{"GET,HEAD", "GET,HEAD,OPTIONS"}
+)
+
+func methodSet(methods []string) string {
+	set := make([]string, len(methods))
+	for i, method := range methods {
+		set[i] = strings.ToUpper(method)
+	}
+	sort.Strings(set)
+	return strings.Join(set, ",")
+}
+
+func isMethodSet(methods []string, sets []string) bool {
+	set := methodSet(methods)
+	for _, s := range sets {
+		if s == set {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMethods logs the behaviors that allow or cache methods CloudFront
+// doesn't let them, the methods are used as they are configured
+func validateMethods(behaviors []types.Behavior) {
+	for _, behavior := range behaviors {
+		if len(behavior.AllowedMethods) > 0 && !isMethodSet(behavior.AllowedMethods, allowedMethodSets) {
+			logrus.Errorf("bad configuration: behavior %s allows %s, CloudFront only allows GET and HEAD, GET, HEAD and OPTIONS or all methods", behavior.Path, methodSet(behavior.AllowedMethods))
+		}
+
+		if len(behavior.CachedMethods) > 0 && !isMethodSet(behavior.CachedMethods, cachedMethodSets) {
+			logrus.Errorf("bad configuration: behavior %s caches %s, CloudFront only caches GET and HEAD or GET, HEAD and OPTIONS", behavior.Path, methodSet(behavior.CachedMethods))
+		}
+	}
+}
+
+// enforceAllowedMethods rejects the requests with a method the behavior doesn't
+// allow. It reports whether the request was ended.
+func enforceAllowedMethods(w http.ResponseWriter, r *http.Request, config *types.CloudfrontConfig, behavior types.Behavior, requestId uuid.UUID) bool {
+	if behavior.Allows(r.Method) {
+		return false
+	}
+
+	cloudfrontError{
+		Status:  http.StatusForbidden,
+		Type:    ErrorTypeError,
+		Message: "This distribution is not configured to allow the HTTP request method that was used for this request. The distribution supports only cachable requests.",
+	}.send(w, config, requestId)
+	return true
+}
@@ -1,47 +1,153 @@
 package cloudfront
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
+	"github.com/edwardofclt/cloudfront-emulator/internal/headercase"
 	"github.com/edwardofclt/cloudfront-emulator/internal/types"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 )
 
 func writeRequestHeaders(w http.ResponseWriter, respData types.CfHeaderArray) {
-	for _, header := range respData {
+	for key, header := range respData {
 		for _, val := range header {
-			w.Header().Add(val.Key, val.Value)
+			name := val.Key
+			if name == "" {
+				name = key
+			}
+			headercase.Add(w.Header(), name, val.Value)
 		}
 	}
 }
 
-func generateRequestBody(requestId uuid.UUID, eventType types.EventType, r *http.Request) *types.CfRequest {
+func generateRequestBody(requestId uuid.UUID, eventType types.EventType, r *http.Request, clientIP string) *types.CfRequest {
 	p := &types.CfRequest{
 		BaseConfig: types.BaseConfig{
-			ClientIP:    strings.Split(r.RemoteAddr, ":")[0],
+			ClientIP:    clientIP,
 			Method:      r.Method,
 			QueryString: r.URL.RawQuery,
 			URI:         r.URL.Path,
-			Headers:     parseHeaders(r.Header),
+			Headers:     parseHeaders(r.Header, headercase.Request(r), r.ProtoMajor),
+		},
+	}
+
+	// net/http takes the host out of the headers
+	(*p.Headers)["host"] = []types.CfHeader{
+		{
+			Key:   headercase.Original(headercase.Request(r), "Host", r.ProtoMajor),
+			Value: r.Host,
 		},
 	}
 	return p
 }
 
-func parseHeaders(headers http.Header) *types.CfHeaderArray {
-	h := &types.CfHeaderArray{}
+// addOriginRequestHeaders sets the headers CloudFront adds to the request it
+// sends to the origin. The host is the domain of the origin unless the origin
+// request policy forwards the one of the viewer.
+func addOriginRequestHeaders(request *types.CfRequest, policy types.OriginRequestPolicy, requestId uuid.UUID) {
+	if request.Headers == nil {
+		request.Headers = &types.CfHeaderArray{}
+	}
+	headers := *request.Headers
+
+	if !policy.Includes("host") {
+		domain := ""
+		if request.Origin.S3 != nil {
+			domain = request.Origin.S3.DomainName
+		}
+		if request.Origin.Custom != nil {
+			domain = request.Origin.Custom.DomainName
+		}
+		headers["host"] = []types.CfHeader{{Key: "Host", Value: domain}}
+	}
+
+	appendHeader(headers, "Via", viaHeader("2.0", requestId))
+	appendHeader(headers, "X-Forwarded-For", request.ClientIP)
+}
+
+// viaHeader is the value CloudFront adds to the via header, the id of the edge
+// server is derived from the request id
+func viaHeader(version string, requestId uuid.UUID) string {
+	return fmt.Sprintf("%s %s.cloudfront.net (CloudFront)", version, strings.ReplaceAll(requestId.String(), "-", ""))
+}
+
+// appendHeader adds the value to the end of the comma separated list of values
+// of the header
+func appendHeader(headers types.CfHeaderArray, name string, value string) {
+	key := strings.ToLower(name)
+	if existing, ok := headers[key]; ok && len(existing) > 0 {
+		values := []string{}
+		for _, header := range existing {
+			values = append(values, header.Value)
+		}
+		name = existing[0].Key
+		value = strings.Join(append(values, value), ", ")
+	}
+
+	headers[key] = []types.CfHeader{{Key: name, Value: value}}
+}
+
+// includeRequestBody exposes the body that will be sent to the origin to the
+// handler of the event. The body is buffered so it can still be forwarded to
+// the origin afterwards.
+func includeRequestBody(eventType types.EventType, r *http.Request, request *types.CfRequest) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the request body")
+	}
+	r.Body.Close()
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	request.Body = types.NewCfRequestBody(eventType, body)
+	return nil
+}
+
+// applyRequestBody makes the body a handler replaced the one that is sent to
+// the origin and that following handlers see
+func applyRequestBody(r *http.Request, request *types.CfRequest) error {
+	if request.Body == nil || request.Body.Action != types.BodyActionReplace {
+		request.Body = nil
+		return nil
+	}
+
+	body, err := types.DecodeRequestBody(request.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode the request body")
+	}
+
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	request.Body = nil
+	return nil
+}
+
+// parseHeaders converts the headers of the viewer to the format of the event.
+// Header names keep the case the viewer sent them with and every value of a
+// header is kept, except for cookies which CloudFront combines into a single
+// header the way they're sent over HTTP/1.1.
+func parseHeaders(headers http.Header, names map[string]string, protoMajor int) *types.CfHeaderArray {
+	h := types.CfHeaderArray{}
 	for key, val := range headers {
+		name := headercase.Original(names, key, protoMajor)
+		if strings.EqualFold(key, "cookie") {
+			val = []string{strings.Join(val, "; ")}
+		}
+
 		vals := []types.CfHeader{}
 		for _, v := range val {
 			vals = append(vals, types.CfHeader{
-				Key:   key,
+				Key:   name,
 				Value: v,
 			})
 		}
-		hCopy := *h
-		hCopy[strings.ToLower(key)] = vals
-		h = &hCopy
+		h[strings.ToLower(key)] = vals
 	}
-	return h
+	return &h
 }
@@ -11,9 +11,5 @@ func writeResponseHeaders(w http.ResponseWriter, respData types.CfResponse) {
 		return
 	}
 
-	for _, header := range *respData.Headers {
-		for _, val := range header {
-			w.Header().Add(val.Key, val.Value)
-		}
-	}
+	writeRequestHeaders(w, *respData.Headers)
 }
@@ -0,0 +1,88 @@
+package cloudfront
+
+import (
+	"crypto"
+	"fmt"
+	"html"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/signedurls"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const signedRequestErrorPage = `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`
+
+// loadTrustedKeys loads the public keys of the trusted key groups of every
+// behavior, by behavior path and key pair id. Keys that can't be loaded are
+// logged and left out, so the requests signed with them are denied.
+func loadTrustedKeys(config *types.CloudfrontConfig) map[string]map[string]crypto.PublicKey {
+	groups := map[string]map[string]crypto.PublicKey{}
+	for name, publicKeys := range config.KeyGroups {
+		groups[name] = map[string]crypto.PublicKey{}
+		for _, publicKey := range publicKeys {
+			file := publicKey.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(config.WorkingDirectory, file)
+			}
+
+			key, err := signedurls.LoadPublicKey(file)
+			if err != nil {
+				logrus.WithError(err).Errorf("bad configuration: failed to load the key %s of the key group %s", publicKey.ID, name)
+				continue
+			}
+			groups[name][publicKey.ID] = key
+		}
+	}
+
+	trustedKeys := map[string]map[string]crypto.PublicKey{}
+	for _, behavior := range config.Behaviors {
+		if len(behavior.TrustedKeyGroups) == 0 {
+			continue
+		}
+
+		keys := map[string]crypto.PublicKey{}
+		for _, name := range behavior.TrustedKeyGroups {
+			group, ok := groups[name]
+			if !ok {
+				logrus.Errorf("bad configuration: behavior %s trusts the undefined key group %s", behavior.Path, name)
+				continue
+			}
+
+			for id, key := range group {
+				keys[id] = key
+			}
+		}
+		trustedKeys[behavior.Path] = keys
+	}
+	return trustedKeys
+}
+
+// enforceSignedRequests denies the requests that aren't signed by one of the
+// trusted keys of the behavior. It reports whether the request was ended.
+func enforceSignedRequests(w http.ResponseWriter, r *http.Request, config *types.CloudfrontConfig, trustedKeys map[string]crypto.PublicKey, clientIP string, requestId uuid.UUID) bool {
+	if trustedKeys == nil {
+		return false
+	}
+
+	err := signedurls.Verify(r, trustedKeys, clientIP, time.Now())
+	if err == nil {
+		return false
+	}
+
+	signedErr, ok := err.(*signedurls.Error)
+	if !ok {
+		signedErr = &signedurls.Error{Code: signedurls.ErrorAccessDenied, Message: "Access denied", Reason: err.Error()}
+	}
+	logrus.WithField("requestId", requestId).Warn(signedErr)
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Header().Set("Server", "CloudFront")
+	w.Header().Set("X-Cache", fmt.Sprintf("%s from cloudfront", ErrorTypeError))
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, signedRequestErrorPage, signedErr.Code, html.EscapeString(signedErr.Message))
+	return true
+}
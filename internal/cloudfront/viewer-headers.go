@@ -0,0 +1,230 @@
+package cloudfront
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/geoip"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// GeoOverrideHeader picks the geo profile of a single request, it's either
+	// the name of a profile or a country code
+	GeoOverrideHeader = "X-Emulator-Geo"
+	// GeoOverrideQueryParameter does the same as GeoOverrideHeader
+	GeoOverrideQueryParameter = "emulator-geo"
+)
+
+var defaultGeoProfile = types.GeoProfile{
+	Country:           "US",
+	CountryName:       "United States",
+	CountryRegion:     "WA",
+	CountryRegionName: "Washington",
+	City:              "Seattle",
+	PostalCode:        "98101",
+	MetroCode:         "819",
+	TimeZone:          "America/Los_Angeles",
+	Latitude:          "47.61000",
+	Longitude:         "-122.33000",
+	ASN:               "16509",
+}
+
+var (
+	iosRegex     = regexp.MustCompile(`(?i)iphone|ipad|ipod`)
+	androidRegex = regexp.MustCompile(`(?i)android`)
+	tabletRegex  = regexp.MustCompile(`(?i)ipad|tablet|kindle|silk|playbook`)
+	smartTVRegex = regexp.MustCompile(`(?i)smart-?tv|googletv|appletv|bravia|netcast|roku|hbbtv|(tizen|webos).*tv`)
+	mobileRegex  = regexp.MustCompile(`(?i)mobi|iphone|ipod|windows phone|blackberry|opera mini`)
+)
+
+// removeQueryParameter takes the parameter out of the raw query, the rest of
+// the query is left as the viewer sent it. It returns the first value of the
+// parameter and reports whether it was there.
+func removeQueryParameter(rawQuery string, name string) (string, string, bool) {
+	value, found := "", false
+	kept := []string{}
+	for _, segment := range strings.Split(rawQuery, "&") {
+		key, segmentValue, _ := strings.Cut(segment, "=")
+		if key, err := url.QueryUnescape(key); err != nil || key != name {
+			kept = append(kept, segment)
+			continue
+		}
+
+		if !found {
+			value, _ = url.QueryUnescape(segmentValue)
+		}
+		found = true
+	}
+
+	if !found {
+		return "", rawQuery, false
+	}
+	return value, strings.Join(kept, "&"), true
+}
+
+// resolveGeoProfile picks the geo profile of the viewer. The override header
+// and query parameter are taken out of the request so handlers never see them.
+func resolveGeoProfile(config *types.CloudfrontConfig, r *http.Request, clientIP string) types.GeoProfile {
+	override := r.Header.Get(GeoOverrideHeader)
+	r.Header.Del(GeoOverrideHeader)
+
+	if value, rawQuery, ok := removeQueryParameter(r.URL.RawQuery, GeoOverrideQueryParameter); ok {
+		if value != "" {
+			override = value
+		}
+		r.URL.RawQuery = rawQuery
+	}
+
+	if override != "" {
+		return geoProfile(config, override)
+	}
+
+	defaultProfile := geoProfile(config, config.Geo.Default)
+	if config.Geo.Database == "" && config.Geo.ASNDatabase == "" {
+		return defaultProfile
+	}
+
+	ip := viewerGeoIP(r, clientIP)
+	if ip == nil {
+		return defaultProfile
+	}
+
+	profile := types.GeoProfile{}
+	found := false
+	for _, database := range []string{config.Geo.Database, config.Geo.ASNDatabase} {
+		if database == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(database) {
+			database = filepath.Join(config.WorkingDirectory, database)
+		}
+
+		ok, err := geoip.Lookup(database, ip, &profile)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to locate the viewer, using the default geo profile")
+			return defaultProfile
+		}
+		found = found || ok
+	}
+
+	if !found {
+		return defaultProfile
+	}
+
+	return profile
+}
+
+func geoProfile(config *types.CloudfrontConfig, name string) types.GeoProfile {
+	if name == "" {
+		return defaultGeoProfile
+	}
+
+	for profileName, profile := range config.Geo.Profiles {
+		if strings.EqualFold(profileName, name) {
+			return profile
+		}
+	}
+
+	// anything that isn't a profile is treated as a country code
+	return types.GeoProfile{
+		Country: strings.ToUpper(name),
+	}
+}
+
+// viewerGeoIP is the ip the viewer is located from. The first address of the
+// X-Forwarded-For header takes precedence over the client ip so traffic from
+// other ips can be replayed.
+func viewerGeoIP(r *http.Request, clientIP string) net.IP {
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		if ip := net.ParseIP(strings.TrimSpace(strings.Split(forwardedFor, ",")[0])); ip != nil {
+			return ip
+		}
+	}
+
+	return net.ParseIP(clientIP)
+}
+
+// viewerHeaders generates the CloudFront-* headers that describe the viewer
+func viewerHeaders(r *http.Request, clientIP string, geo types.GeoProfile) types.CfHeaderArray {
+	headers := types.CfHeaderArray{}
+	add := func(key string, value string) {
+		if value == "" {
+			return
+		}
+
+		headers[strings.ToLower(key)] = []types.CfHeader{
+			{
+				Key:   key,
+				Value: value,
+			},
+		}
+	}
+
+	protocol := "http"
+	if r.TLS != nil {
+		protocol = "https"
+		handshake := "fullHandshake"
+		if r.TLS.DidResume {
+			handshake = "sessionResumed"
+		}
+		version := strings.Replace(tls.VersionName(r.TLS.Version), "TLS ", "TLSv", 1)
+		add("CloudFront-Viewer-TLS", fmt.Sprintf("%s:%s:%s", version, tls.CipherSuiteName(r.TLS.CipherSuite), handshake))
+	}
+	add("CloudFront-Forwarded-Proto", protocol)
+	add("CloudFront-Viewer-HTTP-Version", fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor))
+
+	if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		add("CloudFront-Viewer-Address", fmt.Sprintf("%s:%s", clientIP, port))
+	}
+
+	add("CloudFront-Viewer-ASN", geo.ASN)
+	add("CloudFront-Viewer-Country", geo.Country)
+	add("CloudFront-Viewer-Country-Name", geo.CountryName)
+	add("CloudFront-Viewer-Country-Region", geo.CountryRegion)
+	add("CloudFront-Viewer-Country-Region-Name", geo.CountryRegionName)
+	add("CloudFront-Viewer-City", geo.City)
+	add("CloudFront-Viewer-Postal-Code", geo.PostalCode)
+	add("CloudFront-Viewer-Metro-Code", geo.MetroCode)
+	add("CloudFront-Viewer-Time-Zone", geo.TimeZone)
+	add("CloudFront-Viewer-Latitude", geo.Latitude)
+	add("CloudFront-Viewer-Longitude", geo.Longitude)
+
+	userAgent := r.UserAgent()
+	isAndroid := androidRegex.MatchString(userAgent)
+	isSmartTV := smartTVRegex.MatchString(userAgent)
+	// android tablets are the android devices that don't claim to be mobile
+	isTablet := !isSmartTV && (tabletRegex.MatchString(userAgent) || (isAndroid && !strings.Contains(strings.ToLower(userAgent), "mobile")))
+	isMobile := isTablet || mobileRegex.MatchString(userAgent)
+	add("CloudFront-Is-Android-Viewer", fmt.Sprint(isAndroid))
+	add("CloudFront-Is-IOS-Viewer", fmt.Sprint(iosRegex.MatchString(userAgent)))
+	add("CloudFront-Is-Mobile-Viewer", fmt.Sprint(isMobile))
+	add("CloudFront-Is-Tablet-Viewer", fmt.Sprint(isTablet))
+	add("CloudFront-Is-SmartTV-Viewer", fmt.Sprint(isSmartTV))
+	add("CloudFront-Is-Desktop-Viewer", fmt.Sprint(!isMobile && !isSmartTV))
+
+	return headers
+}
+
+// addViewerHeaders adds the viewer headers that the origin request policy of
+// the behavior includes to the request
+func addViewerHeaders(request *types.CfRequest, policy types.OriginRequestPolicy, headers types.CfHeaderArray) {
+	if request.Headers == nil {
+		request.Headers = &types.CfHeaderArray{}
+	}
+
+	for _, name := range policy.Headers {
+		key := strings.ToLower(name)
+		if header, ok := headers[key]; ok {
+			(*request.Headers)[key] = header
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package cloudfront
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const redirectPage = `<html>
+<head><title>301 Moved Permanently</title></head>
+<body>
+<center><h1>301 Moved Permanently</h1></center>
+<hr><center>CloudFront</center>
+</body>
+</html>
+`
+
+// listenPort is the main port of the distribution, it only serves HTTPS when
+// it's 443
+func listenPort(config *types.CloudfrontConfig) int {
+	if config.Port != nil {
+		return *config.Port
+	}
+	return 443
+}
+
+// httpsPort is the port viewers are redirected to for HTTPS
+func httpsPort(config *types.CloudfrontConfig) int {
+	if config.HTTPSPort != nil {
+		return *config.HTTPSPort
+	}
+	return 443
+}
+
+// servesBothProtocols reports whether the https port gets its own listener
+// next to the plain HTTP one
+func servesBothProtocols(config *types.CloudfrontConfig, port int) bool {
+	return port != 443 && config.HTTPSPort != nil && *config.HTTPSPort != port
+}
+
+// validateViewerProtocolPolicies logs the behaviors with an unknown viewer
+// protocol policy, they allow all protocols, and the policies that can't work
+// with a single listener
+func validateViewerProtocolPolicies(config *types.CloudfrontConfig) {
+	port := listenPort(config)
+	for _, behavior := range config.Behaviors {
+		switch behavior.ViewerProtocolPolicy {
+		case "", types.ViewerProtocolPolicyAllowAll:
+		case types.ViewerProtocolPolicyRedirectToHTTPS, types.ViewerProtocolPolicyHTTPSOnly:
+			if servesBothProtocols(config, port) {
+				continue
+			}
+
+			if port == 443 {
+				logrus.Errorf("bad configuration: behavior %s has the viewer protocol policy %s but port 443 only serves HTTPS, set port and httpsPort to serve HTTP too", behavior.Path, behavior.ViewerProtocolPolicy)
+			} else {
+				logrus.Errorf("bad configuration: behavior %s has the viewer protocol policy %s but port %d only serves HTTP, set httpsPort to serve HTTPS too", behavior.Path, behavior.ViewerProtocolPolicy, port)
+			}
+		default:
+			logrus.Errorf("bad configuration: behavior %s has an unknown viewer protocol policy %s, all protocols are allowed", behavior.Path, behavior.ViewerProtocolPolicy)
+		}
+	}
+}
+
+// enforceViewerProtocolPolicy redirects or rejects HTTP requests the behavior
+// doesn't allow. It reports whether the request was ended.
+func enforceViewerProtocolPolicy(w http.ResponseWriter, r *http.Request, config *types.CloudfrontConfig, behavior types.Behavior, requestId uuid.UUID) bool {
+	if r.TLS != nil {
+		return false
+	}
+
+	switch behavior.ViewerProtocolPolicy {
+	case types.ViewerProtocolPolicyRedirectToHTTPS:
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if port := httpsPort(config); port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		location := fmt.Sprintf("https://%s%s", host, r.URL.RequestURI())
+
+		w.Header().Set("Location", location)
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Server", "CloudFront")
+		w.Header().Set("X-Cache", "Redirect from cloudfront")
+		w.WriteHeader(http.StatusMovedPermanently)
+		fmt.Fprint(w, redirectPage)
+		logrus.WithField("requestId", requestId).Debugf("redirected to %s", location)
+		return true
+	case types.ViewerProtocolPolicyHTTPSOnly:
+		cloudfrontError{
+			Status:  http.StatusForbidden,
+			Type:    ErrorTypeError,
+			Message: "Bad request. The distribution only accepts HTTPS requests.",
+		}.send(w, config, requestId)
+		return true
+	}
+
+	return false
+}
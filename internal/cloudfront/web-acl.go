@@ -0,0 +1,87 @@
+package cloudfront
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/headercase"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/edwardofclt/cloudfront-emulator/internal/waf"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// loadWebACL loads the rules file of the web ACL, it's nil when there's none
+// or it can't be loaded
+func loadWebACL(config *types.CloudfrontConfig) *waf.WebACL {
+	if config.WebACL == "" {
+		return nil
+	}
+
+	file := config.WebACL
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(config.WorkingDirectory, file)
+	}
+
+	acl, err := waf.Load(file)
+	if err != nil {
+		logrus.WithError(err).Error("bad configuration: failed to load the web ACL, requests aren't filtered")
+		return nil
+	}
+	return acl
+}
+
+// enforceWebACL blocks the requests the rules of the web ACL block, with their
+// custom response or else the custom error page for 403s. It reports whether
+// the request was ended.
+func enforceWebACL(w http.ResponseWriter, router http.Handler, r *http.Request, config *types.CloudfrontConfig, acl *waf.WebACL, clientIP string, geo types.GeoProfile, requestId uuid.UUID) bool {
+	if acl == nil {
+		return false
+	}
+
+	result := acl.Evaluate(r, clientIP, geo.Country, time.Now())
+	logger := logrus.WithField("requestId", requestId)
+	for _, name := range result.Counted {
+		logger.Infof("the WAF rule %s matched the request", name)
+	}
+
+	if result.Action != types.WAFActionBlock {
+		return false
+	}
+
+	if result.Rule == "" {
+		logger.Warn("the WAF blocked the request by default")
+	} else {
+		logger.Warnf("the WAF rule %s blocked the request", result.Rule)
+	}
+
+	if response := result.BlockResponse; response != nil {
+		status := response.Status
+		if status == 0 {
+			status = http.StatusForbidden
+		}
+
+		for _, header := range response.Headers {
+			headercase.Add(w.Header(), header.Name, header.Value)
+		}
+		w.Header().Set("X-Cache", fmt.Sprintf("%s from cloudfront", ErrorTypeError))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write([]byte(response.Body))
+		}
+		return true
+	}
+
+	if sendCustomErrorPage(w, router, r, config, http.StatusForbidden, requestId) {
+		return true
+	}
+
+	cloudfrontError{
+		Status:  http.StatusForbidden,
+		Type:    ErrorTypeError,
+		Message: "Request blocked. We can't connect to the server for this app or website at this time.",
+	}.send(w, config, requestId)
+	return true
+}
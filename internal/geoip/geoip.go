@@ -0,0 +1,123 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// databases are opened once and shared between requests
+var (
+	databases     = map[string]*maxminddb.Reader{}
+	databasesLock sync.Mutex
+)
+
+// record covers the fields of both the City and the ASN databases, the fields
+// a database doesn't have are left empty
+type record struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+		MetroCode uint     `maxminddb:"metro_code"`
+		TimeZone  string   `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	AutonomousSystemNumber uint `maxminddb:"autonomous_system_number"`
+}
+
+func open(path string) (*maxminddb.Reader, error) {
+	databasesLock.Lock()
+	defer databasesLock.Unlock()
+
+	if db, ok := databases[path]; ok {
+		return db, nil
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open the geoip database %s", path)
+	}
+
+	databases[path] = db
+	return db, nil
+}
+
+// Close closes the databases that were opened, they're opened again by the
+// next lookup so a changed database file is picked up
+func Close() {
+	databasesLock.Lock()
+	defer databasesLock.Unlock()
+
+	for path, db := range databases {
+		if err := db.Close(); err != nil {
+			logrus.WithError(err).Errorf("failed to close the geoip database %s", path)
+		}
+		delete(databases, path)
+	}
+}
+
+// Lookup fills the profile with what the MaxMind database at the path knows
+// about the ip. It reports whether the ip was found in the database.
+func Lookup(path string, ip net.IP, profile *types.GeoProfile) (bool, error) {
+	db, err := open(path)
+	if err != nil {
+		return false, err
+	}
+
+	r := record{}
+	_, found, err := db.LookupNetwork(ip, &r)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to look up %s", ip)
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	set := func(to *string, value string) {
+		if value != "" {
+			*to = value
+		}
+	}
+
+	set(&profile.Country, r.Country.ISOCode)
+	set(&profile.CountryName, r.Country.Names["en"])
+	set(&profile.City, r.City.Names["en"])
+	set(&profile.PostalCode, r.Postal.Code)
+	set(&profile.TimeZone, r.Location.TimeZone)
+	if len(r.Subdivisions) > 0 {
+		set(&profile.CountryRegion, r.Subdivisions[0].ISOCode)
+		set(&profile.CountryRegionName, r.Subdivisions[0].Names["en"])
+	}
+	if r.Location.MetroCode != 0 {
+		profile.MetroCode = strconv.FormatUint(uint64(r.Location.MetroCode), 10)
+	}
+	if r.Location.Latitude != nil && r.Location.Longitude != nil {
+		profile.Latitude = fmt.Sprintf("%.5f", *r.Location.Latitude)
+		profile.Longitude = fmt.Sprintf("%.5f", *r.Location.Longitude)
+	}
+	if r.AutonomousSystemNumber != 0 {
+		profile.ASN = strconv.FormatUint(uint64(r.AutonomousSystemNumber), 10)
+	}
+
+	return true, nil
+}
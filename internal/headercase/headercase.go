@@ -0,0 +1,375 @@
+package headercase
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxLineLength caps how much of a single line is kept while scanning, lines
+// that are longer can't be header lines anyway
+const maxLineLength = 16 * 1024
+
+// maxPendingHeads caps the header blocks of pipelined requests that are kept
+// until their request is handled
+const maxPendingHeads = 32
+
+var (
+	requestLineRegex = regexp.MustCompile(`^[A-Z]+ \S+ HTTP/1\.[01]$`)
+	statusLineRegex  = regexp.MustCompile(`^HTTP/1\.[01] \d{3}`)
+)
+
+// managedHeaders are looked up by net/http when it writes a message, so they
+// always have to be written with their canonical name
+var managedHeaders = map[string]struct{}{
+	"connection":        {},
+	"content-length":    {},
+	"content-type":      {},
+	"date":              {},
+	"host":              {},
+	"trailer":           {},
+	"transfer-encoding": {},
+	"user-agent":        {},
+}
+
+type (
+	connKey  struct{}
+	namesKey struct{}
+)
+
+// the states of the scanner of a connection
+const (
+	// stateStart waits for the start line of the next message
+	stateStart = iota
+	// stateHead reads the header lines until the blank line ending them
+	stateHead
+	// stateBody skips the body, or a chunk of it
+	stateBody
+	// stateChunkSize reads the size line of the next chunk
+	stateChunkSize
+	// stateTrailer reads the trailer lines of a chunked body
+	stateTrailer
+	// stateIgnore stops scanning until the next message boundary is known
+	stateIgnore
+)
+
+type head struct {
+	// line is the method and the target of a request
+	line          string
+	names         map[string]string
+	contentLength string
+	chunked       bool
+}
+
+// Conn records the names of the headers of the HTTP/1.x messages read from the
+// connection the way they were written on the wire, since net/http
+// canonicalizes them. Only the header blocks are scanned, bodies are skipped.
+type Conn struct {
+	net.Conn
+	// requests is true for the connections of a server, they read requests
+	// while the connections of a client read responses
+	requests bool
+
+	lock      sync.Mutex
+	state     int
+	line      []byte
+	current   head
+	remaining int64
+	chunked   bool
+	// pending are the header blocks of the requests that weren't handled yet
+	pending []head
+	// last are the header names of the last response
+	last map[string]string
+}
+
+// Listener accepts connections that record the header names of the requests
+// they receive
+type Listener struct {
+	net.Listener
+}
+
+func (l Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Conn: conn, requests: true}, nil
+}
+
+// ConnContext makes the connection available to the handlers of a server, it's
+// meant to be used as http.Server.ConnContext
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if conn, ok := c.(*Conn); ok {
+		return context.WithValue(ctx, connKey{}, conn)
+	}
+	return ctx
+}
+
+// Handler hands each request the header names that were recorded for it, the
+// server has to use Listener and ConnContext
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, ok := r.Context().Value(connKey{}).(*Conn); ok {
+			names := conn.next(r.Method + " " + r.RequestURI)
+			r = r.WithContext(context.WithValue(r.Context(), namesKey{}, names))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Dial wraps a dial function so the connections it opens record the header
+// names of the responses they receive
+func Dial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Conn{Conn: conn}, nil
+	}
+}
+
+// Request returns the header names of the request as the viewer sent them,
+// keyed by their lowercase name. It's nil when they weren't recorded.
+func Request(r *http.Request) map[string]string {
+	names, _ := r.Context().Value(namesKey{}).(map[string]string)
+	return names
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.scan(b[:n])
+	return n, err
+}
+
+// Write starts a new message on the connections of a client, the response to
+// the request is read next
+func (c *Conn) Write(b []byte) (int, error) {
+	if !c.requests {
+		c.lock.Lock()
+		if c.state == stateIgnore {
+			c.state = stateStart
+			c.line = c.line[:0]
+		}
+		c.lock.Unlock()
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *Conn) scan(data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for len(data) > 0 {
+		switch c.state {
+		case stateIgnore:
+			return
+		case stateBody:
+			n := int64(len(data))
+			if n > c.remaining {
+				n = c.remaining
+			}
+			data = data[n:]
+			c.remaining -= n
+			if c.remaining == 0 {
+				c.state = stateStart
+				if c.chunked {
+					c.state = stateChunkSize
+				}
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			c.appendLine(data)
+			return
+		}
+		c.appendLine(data[:i])
+		data = data[i+1:]
+
+		line := strings.TrimSuffix(string(c.line), "\r")
+		c.line = c.line[:0]
+		c.scanLine(line)
+	}
+}
+
+func (c *Conn) appendLine(data []byte) {
+	if room := maxLineLength - len(c.line); room > 0 {
+		if len(data) > room {
+			data = data[:room]
+		}
+		c.line = append(c.line, data...)
+	}
+}
+
+func (c *Conn) scanLine(line string) {
+	switch c.state {
+	case stateStart:
+		if c.requests && requestLineRegex.MatchString(line) {
+			c.current = head{line: line[:strings.LastIndexByte(line, ' ')], names: map[string]string{}}
+			c.state = stateHead
+		} else if !c.requests && statusLineRegex.MatchString(line) {
+			c.current = head{line: line, names: map[string]string{}}
+			c.state = stateHead
+		}
+	case stateHead:
+		if line == "" {
+			c.endHead()
+			return
+		}
+
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			name := strings.TrimSpace(line[:i])
+			value := strings.TrimSpace(line[i+1:])
+			switch lower := strings.ToLower(name); lower {
+			case "content-length":
+				c.current.contentLength = value
+			case "transfer-encoding":
+				c.current.chunked = c.current.chunked || strings.Contains(strings.ToLower(value), "chunked")
+			}
+			c.current.names[strings.ToLower(name)] = name
+		}
+	case stateChunkSize:
+		size := line
+		if i := strings.IndexByte(size, ';'); i >= 0 {
+			size = size[:i]
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		switch {
+		case err != nil || n < 0:
+			c.state = stateIgnore
+		case n == 0:
+			c.state = stateTrailer
+		default:
+			// the chunk is followed by a line break
+			c.remaining = n + 2
+			c.state = stateBody
+		}
+	case stateTrailer:
+		if line == "" {
+			c.state = stateStart
+		}
+	}
+}
+
+// endHead finds where the message that was just read ends. The boundaries of
+// the responses aren't tracked, the next one follows the next request.
+func (c *Conn) endHead() {
+	if !c.requests {
+		c.last = c.current.names
+		c.state = stateIgnore
+		// interim responses are followed by the final one
+		if strings.HasPrefix(c.current.line[strings.IndexByte(c.current.line, ' ')+1:], "1") {
+			c.state = stateStart
+		}
+		return
+	}
+
+	c.pending = append(c.pending, c.current)
+	if len(c.pending) > maxPendingHeads {
+		c.pending = c.pending[len(c.pending)-maxPendingHeads:]
+	}
+
+	c.chunked = c.current.chunked
+	c.state = stateStart
+	switch {
+	case c.current.chunked:
+		c.state = stateChunkSize
+	case c.current.contentLength != "":
+		n, err := strconv.ParseInt(c.current.contentLength, 10, 64)
+		if err != nil || n < 0 {
+			c.state = stateIgnore
+		} else if n > 0 {
+			c.remaining = n
+			c.state = stateBody
+		}
+	}
+}
+
+// next returns the header names of the request with the request line, the
+// header blocks of the requests before it are dropped
+func (c *Conn) next(line string) map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, pending := range c.pending {
+		if pending.line == line {
+			c.pending = c.pending[i+1:]
+			return pending.names
+		}
+	}
+	return nil
+}
+
+// Names returns the header names of the last response read from the
+// connection, keyed by their lowercase name
+func (c *Conn) Names() map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.last
+}
+
+// Original returns the name of the header as it was recorded. HTTP/2 header
+// names are always lowercase and anything else that wasn't recorded falls back
+// to the canonical name.
+func Original(names map[string]string, key string, protoMajor int) string {
+	if name, ok := names[strings.ToLower(key)]; ok {
+		return name
+	}
+
+	if protoMajor == 2 {
+		return strings.ToLower(key)
+	}
+
+	return http.CanonicalHeaderKey(key)
+}
+
+// Add adds the header to the header map keeping the case of its name, net/http
+// writes the names of the map as is
+func Add(header http.Header, key string, value string) {
+	if _, ok := managedHeaders[strings.ToLower(key)]; ok {
+		header.Add(key, value)
+		return
+	}
+
+	// merge with a header that was added with another case
+	for existing := range header {
+		if existing != key && strings.EqualFold(existing, key) {
+			key = existing
+			break
+		}
+	}
+
+	header[key] = append(header[key], value)
+}
+
+// Get returns the first value of the header whatever the case of its name
+func Get(header http.Header, key string) string {
+	if value := header.Get(key); value != "" {
+		return value
+	}
+
+	for existing, values := range header {
+		if strings.EqualFold(existing, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// Del removes the header from the header map whatever the case of its name
+func Del(header http.Header, key string) {
+	for existing := range header {
+		if strings.EqualFold(existing, key) {
+			delete(header, existing)
+		}
+	}
+}
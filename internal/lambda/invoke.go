@@ -0,0 +1,169 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	RuntimeNode   = "nodejs"
+	RuntimePython = "python"
+)
+
+// InvokeExecution describes a regional lambda invocation. Unlike the edge
+// handlers the event is written to stdin and the result is read from a file,
+// so the handler can be either async or callback based.
+type InvokeExecution struct {
+	WorkingDirectory string
+	Runtime          string
+	Path             string
+	Handler          string
+	Payload          []byte
+	// Timeout stops the handler when it runs for longer, there's none when
+	// it's zero
+	Timeout time.Duration
+}
+
+const nodeInvokeCommand = `const fs = require('fs')
+const event = JSON.parse(fs.readFileSync(0, 'utf8'))
+const context = { functionName: 'local', awsRequestId: event.requestContext && event.requestContext.requestId }
+
+{{if .Module}}const load = import({{json (print "./" .Path)}}){{else}}const load = Promise.resolve(require({{json (print "./" .Path)}})){{end}}
+
+load.then(m => new Promise((resolve, reject) => {
+	const result = m.{{.Handler}}(event, context, (error, response) => error ? reject(error) : resolve(response))
+	if (result && typeof result.then === 'function') {
+		result.then(resolve, reject)
+	}
+})).then(response => {
+	fs.writeFileSync({{json .ResultFile}}, JSON.stringify(response === undefined ? null : response))
+}).catch(error => {
+	console.error(error)
+	process.exit(1)
+})`
+
+const pythonInvokeCommand = `import importlib, json, sys
+sys.path.insert(0, {{printf "%q" .Directory}})
+
+class Context:
+    function_name = "local"
+
+event = json.load(sys.stdin)
+Context.aws_request_id = event.get("requestContext", {}).get("requestId")
+
+module = importlib.import_module({{printf "%q" .Module}})
+response = getattr(module, {{printf "%q" .Handler}})(event, Context())
+
+with open({{printf "%q" .ResultFile}}, "w") as f:
+    json.dump(response, f)`
+
+// nodeTemplateFuncs quote the values of the node command, a JSON string is a
+// valid javascript string
+var nodeTemplateFuncs = template.FuncMap{
+	"json": func(value string) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+type invokeTemplateValues struct {
+	Path       string
+	Directory  string
+	Module     string
+	Handler    string
+	ResultFile string
+}
+
+// Invoke runs a regional lambda handler and returns the JSON encoded value it
+// responded with
+func Invoke(config InvokeExecution) ([]byte, error) {
+	handlerDefinition := strings.Split(config.Handler, ".")
+	if len(handlerDefinition) != 2 {
+		return nil, fmt.Errorf("handler must look like file.function, got %s", config.Handler)
+	}
+
+	resultFile, err := os.CreateTemp("", "cloudfront-emulator-result-*.json")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the result file")
+	}
+	resultFile.Close()
+	defer os.Remove(resultFile.Name())
+
+	templateValues := &invokeTemplateValues{
+		Directory:  filepath.Join(config.WorkingDirectory, config.Path),
+		Module:     handlerDefinition[0],
+		Handler:    handlerDefinition[1],
+		ResultFile: resultFile.Name(),
+	}
+
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	command := &bytes.Buffer{}
+	switch config.Runtime {
+	case "", RuntimeNode:
+		templateValues.Path = filepath.Clean(fmt.Sprintf("./%s/%s.js", config.Path, handlerDefinition[0]))
+		templateValues.Module = ""
+
+		packageFile := &Package{}
+		if packageFileContent, err := os.ReadFile(filepath.Join(config.WorkingDirectory, "package.json")); err == nil {
+			if err := json.Unmarshal(packageFileContent, packageFile); err != nil {
+				return nil, err
+			}
+		}
+		if packageFile.Type == "module" {
+			templateValues.Module = "module"
+		}
+
+		if err := template.Must(template.New("command").Funcs(nodeTemplateFuncs).Parse(nodeInvokeCommand)).Execute(command, templateValues); err != nil {
+			return nil, errors.Wrap(err, "failed to generate the node command")
+		}
+		cmd = exec.CommandContext(ctx, "node", "-e", command.String())
+	case RuntimePython:
+		if err := template.Must(template.New("command").Parse(pythonInvokeCommand)).Execute(command, templateValues); err != nil {
+			return nil, errors.Wrap(err, "failed to generate the python command")
+		}
+		cmd = exec.CommandContext(ctx, "python3", "-c", command.String())
+	default:
+		return nil, fmt.Errorf("unsupported runtime: %s", config.Runtime)
+	}
+
+	cmd.Dir = config.WorkingDirectory
+	cmd.Stdin = bytes.NewReader(config.Payload)
+	resp, err := cmd.CombinedOutput()
+
+	// output the logs from the lambda before throwing the error
+	if len(resp) > 0 {
+		fmt.Print(string(resp))
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return resp, ErrTimeout
+	}
+
+	if err != nil {
+		return resp, errors.Wrap(err, "failed to execute the command")
+	}
+
+	result, err := os.ReadFile(resultFile.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the lambda result")
+	}
+
+	return result, nil
+}
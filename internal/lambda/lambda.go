@@ -2,6 +2,7 @@ package lambda
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http/httptest"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/edwardofclt/cloudfront-emulator/internal/types"
 	"github.com/pkg/errors"
@@ -24,47 +26,114 @@ type Package struct {
 type LambdaExecution struct {
 	Callback         *httptest.Server
 	WorkingDirectory string
+	EventType        types.EventType
 	Context          types.Event
 	Payload          []byte
 	Waitgroup        *sync.WaitGroup
 }
 
-const defaultLambdaCommand = `require('./{{.Path}}').{{.Handler}}({{.Payload}}, 'f', async (error, response) => {
-	if (error) {
-		throw new Error(error)
+var (
+	// ErrTimeout is returned when the handler runs for longer than its timeout
+	ErrTimeout = errors.New("the function timed out")
+	// ErrThrottled is returned when the handler is already running as many
+	// times as its reserved concurrency allows
+	ErrThrottled = errors.New("the function was throttled")
+)
+
+// running counts the executions of every handler that has a reserved
+// concurrency
+var (
+	running     = map[string]int{}
+	runningLock sync.Mutex
+)
+
+// Timeout is how long the handler of the event type can run, which can't be
+// longer than what CloudFront allows for the trigger
+func Timeout(eventType types.EventType, event types.Event) time.Duration {
+	limit := types.MaxOriginTimeout
+	if eventType == types.ViewerRequest || eventType == types.ViewerResponse {
+		limit = types.MaxViewerTimeout
 	}
 
-	const req = http.request("{{.CallbackURL}}", {
+	if event.Timeout <= 0 {
+		return limit
+	}
+
+	if event.Timeout > limit {
+		logrus.Warnf("bad configuration: %s handlers can't run for more than %s, got %s", eventType, limit, event.Timeout)
+		return limit
+	}
+
+	return event.Timeout
+}
+
+// reserve counts the execution against the reserved concurrency of the
+// handler, it reports false when the handler should be throttled
+func reserve(key string, limit int) (release func(), ok bool) {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+
+	if running[key] >= limit {
+		return nil, false
+	}
+	running[key]++
+
+	return func() {
+		runningLock.Lock()
+		defer runningLock.Unlock()
+		running[key]--
+	}, true
+}
+
+// lambdaCallback posts the response of the handler to the callback server. The
+// handler can either call the callback, whenever it wants to, or return a
+// promise. /done is posted when the promise resolves to nothing, a handler that
+// does neither exits without a response.
+const lambdaCallback = `let called = false
+const respond = (path, response) => {
+	const req = http.request("{{.CallbackURL}}" + path, {
 		method: "POST",
 	})
-	req.write(JSON.stringify(response))
-	req.end()	
-})
-
-const req = http.request("{{.CallbackURL}}", {
-	method: "POST",
-})
-req.write(JSON.stringify({{.Payload}}))
-req.end()`
-
-const moduleLambdaCommand = `let module;
-import('./{{.Path}}').then(m => m.{{.Handler}}({{.Payload}}, 'f', async (error, response) => {
+	if (response !== undefined) {
+		req.write(JSON.stringify(response))
+	}
+	req.end()
+}
+
+const callback = async (error, response) => {
+	if (called) {
+		return
+	}
+	called = true
+
 	if (error) {
 		throw new Error(error)
 	}
 
-	const req = http.request("{{.CallbackURL}}", {
-		method: "POST",
-	})
-	req.write(JSON.stringify(response))
-	req.end()	
-})).then(() => {
-	const req = http.request("{{.CallbackURL}}", {
-		method: "POST",
-	})
-	req.write(JSON.stringify({{.Payload}}))
-	req.end()	
-});`
+	respond("/", response)
+}
+
+const done = response => {
+	if (called) {
+		return
+	}
+	called = true
+
+	respond(response === undefined ? "/done" : "/", response)
+}
+
+const run = result => {
+	if (result && typeof result.then === "function") {
+		return result.then(done)
+	}
+}
+`
+
+const defaultLambdaCommand = lambdaCallback + `
+run(require('./{{.Path}}').{{.Handler}}({{.Payload}}, 'f', callback))`
+
+const moduleLambdaCommand = lambdaCallback + `
+import('./{{.Path}}').then(m => run(m.{{.Handler}}({{.Payload}}, 'f', callback)))`
 
 type LambdaTemplateValues struct {
 	Path        string
@@ -78,6 +147,15 @@ func Run(config LambdaExecution) ([]byte, error) {
 
 	cmd := new(exec.Cmd)
 
+	if config.Context.ReservedConcurrency > 0 {
+		key := filepath.Join(config.WorkingDirectory, config.Context.Path, config.Context.Handler)
+		release, ok := reserve(key, config.Context.ReservedConcurrency)
+		if !ok {
+			return nil, ErrThrottled
+		}
+		defer release()
+	}
+
 	handlerDefinition := strings.Split(config.Context.Handler, ".")
 
 	packageFilePath := filepath.Join(config.WorkingDirectory, "package.json")
@@ -114,7 +192,10 @@ func Run(config LambdaExecution) ([]byte, error) {
 		tmpl.Execute(command, templateValues)
 	}
 
-	cmd = exec.Command("node", "-e", command.String())
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout(config.EventType, config.Context))
+	defer cancel()
+
+	cmd = exec.CommandContext(ctx, "node", "-e", command.String())
 	cmd.Dir = config.WorkingDirectory
 	resp, err := cmd.CombinedOutput()
 
@@ -126,6 +207,10 @@ func Run(config LambdaExecution) ([]byte, error) {
 		}
 	}
 
+	if ctx.Err() == context.DeadlineExceeded {
+		return resp, ErrTimeout
+	}
+
 	if err != nil {
 		return resp, errors.Wrap(err, "failed to execute the command")
 	}
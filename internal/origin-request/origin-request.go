@@ -2,7 +2,6 @@ package originrequest
 
 import (
 	"github.com/edwardofclt/cloudfront-emulator/internal/types"
-	"github.com/pkg/errors"
 )
 
 type OriginRequestEvent struct {
@@ -13,28 +12,19 @@ func New() types.CloudfrontEvent {
 }
 
 func (e *OriginRequestEvent) Execute(config types.CloudfrontEventInput) error {
-	err := validateRequest(types.OriginRequest, *config.CfRequest, config.CallbackResponse)
+	err := types.ValidateRequestCallback(types.OriginRequest, config)
 	if err != nil {
 		return err
 	}
 
-	config.CfRequest.BaseConfig = types.MergeBaseConfigs(config.CfRequest.BaseConfig, config.CallbackResponse.BaseConfig)
-	types.MergeHeaders(config.CfRequest.Headers, config.CallbackResponse.Headers)
-
-	return nil
-}
-
-func validateRequest(eventType types.EventType, request types.CfRequest, response types.CallbackResponse) error {
-	if response.Headers != nil {
-		if err := types.CheckHeaders(eventType, *request.Headers, *response.Headers); err != nil {
-			return err
-		}
+	// a generated response leaves the request as it was
+	if config.Returned && config.CallbackResponse.Status == nil {
+		types.ReplaceRequest(config.CfRequest, config.CallbackResponse.BaseConfig)
+	}
+	types.ReplaceRequestBody(config.CfRequest, config.CallbackResponse.RequestBody)
 
-		for _, header := range *response.Headers {
-			if err := types.CheckReadOnlyHeader(types.OriginRequestReadOnlyHeaders, header, *request.Headers); err != nil {
-				return errors.Wrap(err, "read only headeres were modified")
-			}
-		}
+	if config.CallbackResponse.Origin != nil {
+		config.CfRequest.Origin = config.CallbackResponse.Origin
 	}
 
 	return nil
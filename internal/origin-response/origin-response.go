@@ -12,34 +12,31 @@ func New() types.CloudfrontEvent {
 }
 
 func (e *OriginResponseEvent) Execute(config types.CloudfrontEventInput) error {
-	err := validateResponse(types.OriginRequest, *config.CfRequest, config.CallbackResponse)
+	err := types.ValidateResponseCallback(types.OriginResponse, config)
 	if err != nil {
 		return err
 	}
 
-	config.CfResponse.BaseConfig = types.MergeBaseConfigs(config.CfResponse.BaseConfig, config.CallbackResponse.BaseConfig)
-	types.MergeHeaders(config.CfResponse.Headers, config.FinalResponse.Headers)
-	types.MergeHeaders(config.CfResponse.Headers, config.CallbackResponse.Headers)
-	if config.CallbackResponse.Headers != nil {
-		// TODO: make sure this makes sense and headers are showing up properly
-		types.MergeHeaders(config.FinalResponse.Headers, config.CfResponse.Headers)
+	// the response stays as it was when the handler didn't return one
+	if !config.Returned {
+		return nil
 	}
-	return nil
-}
-
-func validateResponse(eventType types.EventType, request types.CfRequest, response types.CallbackResponse) error {
-	if response.Headers != nil {
-		if err := types.CheckHeaders(eventType, *request.Headers, *response.Headers); err != nil {
-			return err
-		}
 
-		// TODO: Validate that the headers that are being modified are allowed
-		// for _, header := range *response.Headers {
-		// 	if err := types.CheckReadOnlyHeader(types.OriginRequestReadOnlyHeaders, header, *request.Headers); err != nil {
-		// 		return errors.Wrap(err, "read only headeres were modified")
-		// 	}
-		// }
+	callback := config.CallbackResponse
+	if callback.Status != nil {
+		config.CfResponse.Status = callback.Status
+	}
+	if callback.StatusDescription != nil {
+		config.CfResponse.StatusDescription = callback.StatusDescription
 	}
 
+	// the headers the handler removed don't reach the viewer either
+	if config.CfResponse.Headers == nil {
+		config.CfResponse.Headers = &types.CfHeaderArray{}
+	}
+	types.ReplaceHeaders(*config.CfResponse.Headers, callback.Headers)
+	if config.FinalResponse != nil && config.FinalResponse.Headers != nil {
+		types.ReplaceHeaders(*config.FinalResponse.Headers, callback.Headers)
+	}
 	return nil
 }
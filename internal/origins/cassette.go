@@ -0,0 +1,292 @@
+package origins
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/headercase"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	CassetteModeAuto   = "auto"
+	CassetteModeRecord = "record"
+	CassetteModeReplay = "replay"
+)
+
+// cassettes are shared between requests so every recording ends up in the
+// same file, they're read again when the file changes
+var (
+	cassettes     = map[string]*cassette{}
+	cassettesLock sync.Mutex
+)
+
+type cassette struct {
+	path string
+	lock sync.Mutex
+	// modTime is when the file was last read or written
+	modTime      time.Time
+	Interactions []cassetteInteraction `json:"interactions"`
+}
+
+type cassetteInteraction struct {
+	Request  cassetteRequest  `json:"request"`
+	Response cassetteResponse `json:"response"`
+}
+
+type cassetteRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type cassetteResponse struct {
+	Status       int                 `json:"status"`
+	Headers      map[string][]string `json:"headers,omitempty"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"bodyEncoding"`
+}
+
+type cassetteTransport struct {
+	next     http.RoundTripper
+	cassette *cassette
+	mode     string
+	headers  []string
+}
+
+func newCassetteTransport(next http.RoundTripper, config *types.OriginCassette, workingDirectory string) (*cassetteTransport, error) {
+	mode := config.Mode
+	if mode == "" {
+		mode = CassetteModeAuto
+	}
+
+	if mode != CassetteModeAuto && mode != CassetteModeRecord && mode != CassetteModeReplay {
+		return nil, fmt.Errorf("unknown cassette mode: %s", mode)
+	}
+
+	file := config.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(workingDirectory, file)
+	}
+
+	c, err := loadCassette(file)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := []string{}
+	for _, header := range config.MatchHeaders {
+		headers = append(headers, strings.ToLower(header))
+	}
+	sort.Strings(headers)
+
+	return &cassetteTransport{
+		next:     next,
+		cassette: c,
+		mode:     mode,
+		headers:  headers,
+	}, nil
+}
+
+func loadCassette(path string) (*cassette, error) {
+	cassettesLock.Lock()
+	defer cassettesLock.Unlock()
+
+	modTime := time.Time{}
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	if c, ok := cassettes[path]; ok && c.unchanged(modTime) {
+		return c, nil
+	}
+
+	c := &cassette{path: path, modTime: modTime}
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read the cassette")
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(content, c); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the cassette %s", path)
+		}
+	}
+
+	cassettes[path] = c
+	return c, nil
+}
+
+// unchanged reports whether the file wasn't changed since it was last read or
+// written by the cassette
+func (c *cassette) unchanged(modTime time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.modTime.Equal(modTime)
+}
+
+func (t *cassetteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	request := cassetteRequest{
+		Method:  r.Method,
+		URL:     r.URL.String(),
+		Headers: map[string]string{},
+	}
+	for _, header := range t.headers {
+		if value := headercase.Get(r.Header, header); value != "" {
+			request.Headers[header] = value
+		}
+	}
+
+	if t.mode != CassetteModeRecord {
+		if interaction, ok := t.cassette.find(request); ok {
+			if r.Body != nil {
+				r.Body.Close()
+			}
+			return interaction.Response.toHTTPResponse(r)
+		}
+
+		if t.mode == CassetteModeReplay {
+			return nil, fmt.Errorf("the cassette %s has no recording for %s %s", t.cassette.path, r.Method, request.URL)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the response to record")
+	}
+
+	response := cassetteResponse{
+		Status:       resp.StatusCode,
+		Headers:      resp.Header,
+		Body:         string(body),
+		BodyEncoding: types.BodyEncodingText,
+	}
+	if !utf8.Valid(body) {
+		response.Body = base64.StdEncoding.EncodeToString(body)
+		response.BodyEncoding = types.BodyEncodingBase64
+	}
+
+	if err := t.cassette.record(cassetteInteraction{Request: request, Response: response}); err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func (c *cassette) find(request cassetteRequest) (cassetteInteraction, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, interaction := range c.Interactions {
+		if interaction.Request.matches(request) {
+			return interaction, true
+		}
+	}
+
+	return cassetteInteraction{}, false
+}
+
+// record adds the interaction to the cassette, replacing any previous
+// recording of the same request, and saves the cassette to disk
+func (c *cassette) record(interaction cassetteInteraction) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	replaced := false
+	for i := range c.Interactions {
+		if c.Interactions[i].Request.matches(interaction.Request) {
+			c.Interactions[i] = interaction
+			replaced = true
+			break
+		}
+	}
+
+	if !replaced {
+		c.Interactions = append(c.Interactions, interaction)
+	}
+
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the cassette")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create the cassette directory")
+	}
+
+	if err := os.WriteFile(c.path, content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write the cassette")
+	}
+
+	// the recordings of the cassette don't make it read the file again
+	if info, err := os.Stat(c.path); err == nil {
+		c.modTime = info.ModTime()
+	}
+
+	return nil
+}
+
+func (r cassetteRequest) matches(other cassetteRequest) bool {
+	if r.Method != other.Method || r.URL != other.URL || len(r.Headers) != len(other.Headers) {
+		return false
+	}
+
+	for key, value := range r.Headers {
+		if other.Headers[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r cassetteResponse) toHTTPResponse(request *http.Request) (*http.Response, error) {
+	body := []byte(r.Body)
+	if r.BodyEncoding == types.BodyEncodingBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(r.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode the recorded body")
+		}
+		body = decoded
+	}
+
+	header := http.Header{}
+	for key, values := range r.Headers {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}, nil
+}
@@ -0,0 +1,249 @@
+package origins
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/headercase"
+	"github.com/edwardofclt/cloudfront-emulator/internal/lambda"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// functionURLEvent is the payload format version 2.0 event that is shared by
+// lambda function URLs and API Gateway HTTP APIs
+type functionURLEvent struct {
+	Version               string                         `json:"version"`
+	RouteKey              string                         `json:"routeKey"`
+	RawPath               string                         `json:"rawPath"`
+	RawQueryString        string                         `json:"rawQueryString"`
+	Cookies               []string                       `json:"cookies,omitempty"`
+	Headers               map[string]string              `json:"headers"`
+	QueryStringParameters map[string]string              `json:"queryStringParameters,omitempty"`
+	RequestContext        functionURLEventRequestContext `json:"requestContext"`
+	Body                  string                         `json:"body,omitempty"`
+	IsBase64Encoded       bool                           `json:"isBase64Encoded"`
+}
+
+type functionURLEventRequestContext struct {
+	AccountID    string               `json:"accountId"`
+	APIID        string               `json:"apiId"`
+	DomainName   string               `json:"domainName"`
+	DomainPrefix string               `json:"domainPrefix"`
+	HTTP         functionURLEventHTTP `json:"http"`
+	RequestID    string               `json:"requestId"`
+	RouteKey     string               `json:"routeKey"`
+	Stage        string               `json:"stage"`
+	Time         string               `json:"time"`
+	TimeEpoch    int64                `json:"timeEpoch"`
+}
+
+type functionURLEventHTTP struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Protocol  string `json:"protocol"`
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+type functionURLResponse struct {
+	StatusCode      *int              `json:"statusCode"`
+	Headers         map[string]string `json:"headers"`
+	Cookies         []string          `json:"cookies"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+type functionURLTransport struct {
+	config           *types.LambdaOrigin
+	workingDirectory string
+	readTimeout      time.Duration
+}
+
+func newFunctionURLTransport(config *types.LambdaOrigin, workingDirectory string, readTimeout time.Duration) (*functionURLTransport, error) {
+	if config == nil || config.Handler == "" {
+		return nil, fmt.Errorf("lambda origins need a handler")
+	}
+
+	return &functionURLTransport{
+		config:           config,
+		workingDirectory: workingDirectory,
+		readTimeout:      readTimeout,
+	}, nil
+}
+
+func (t *functionURLTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	event, err := newFunctionURLEvent(r)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the lambda event")
+	}
+
+	result, err := lambda.Invoke(lambda.InvokeExecution{
+		WorkingDirectory: t.workingDirectory,
+		Runtime:          t.config.Runtime,
+		Path:             t.config.Path,
+		Handler:          t.config.Handler,
+		Payload:          payload,
+		Timeout:          t.readTimeout,
+	})
+	if errors.Cause(err) == lambda.ErrTimeout {
+		return nil, fmt.Errorf("origin didn't respond within %s", t.readTimeout)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to invoke the lambda origin")
+	}
+
+	return newFunctionURLResponse(r, result)
+}
+
+func newFunctionURLEvent(r *http.Request) (*functionURLEvent, error) {
+	now := time.Now().UTC()
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	event := &functionURLEvent{
+		Version:        "2.0",
+		RouteKey:       "$default",
+		RawPath:        r.URL.EscapedPath(),
+		RawQueryString: r.URL.RawQuery,
+		Headers:        map[string]string{},
+		RequestContext: functionURLEventRequestContext{
+			AccountID:    "anonymous",
+			APIID:        strings.Split(host, ".")[0],
+			DomainName:   host,
+			DomainPrefix: strings.Split(host, ".")[0],
+			HTTP: functionURLEventHTTP{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Protocol:  "HTTP/1.1",
+				SourceIP:  "127.0.0.1",
+				UserAgent: headercase.Get(r.Header, "User-Agent"),
+			},
+			RequestID: uuid.New().String(),
+			RouteKey:  "$default",
+			Stage:     "$default",
+			Time:      now.Format("02/Jan/2006:15:04:05 -0700"),
+			TimeEpoch: now.UnixMilli(),
+		},
+	}
+
+	for key, values := range r.Header {
+		name := strings.ToLower(key)
+		if name == "cookie" {
+			for _, value := range values {
+				for _, cookie := range strings.Split(value, ";") {
+					event.Cookies = append(event.Cookies, strings.TrimSpace(cookie))
+				}
+			}
+			continue
+		}
+
+		event.Headers[name] = strings.Join(values, ",")
+	}
+	event.Headers["host"] = host
+
+	if forwardedFor := headercase.Get(r.Header, "X-Forwarded-For"); forwardedFor != "" {
+		event.RequestContext.HTTP.SourceIP = strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+	}
+
+	if r.URL.RawQuery != "" {
+		query, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse the querystring")
+		}
+
+		event.QueryStringParameters = map[string]string{}
+		for key, values := range query {
+			event.QueryStringParameters[key] = strings.Join(values, ",")
+		}
+	}
+
+	if r.Body != nil {
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the request body")
+		}
+
+		if len(body) > 0 {
+			event.Body = string(body)
+			if !utf8.Valid(body) {
+				event.Body = base64.StdEncoding.EncodeToString(body)
+				event.IsBase64Encoded = true
+			}
+		}
+	}
+
+	return event, nil
+}
+
+// newFunctionURLResponse maps the value returned by the handler to an HTTP
+// response, inferring the response the same way function URLs do when the
+// handler doesn't return a statusCode
+func newFunctionURLResponse(r *http.Request, result []byte) (*http.Response, error) {
+	response := functionURLResponse{}
+	if err := json.Unmarshal(result, &response); err != nil || response.StatusCode == nil {
+		status := http.StatusOK
+		response = functionURLResponse{
+			StatusCode: &status,
+			Headers: map[string]string{
+				"content-type": "application/json",
+			},
+			Body: string(result),
+		}
+
+		// strings are returned as is instead of being JSON encoded
+		var text string
+		if err := json.Unmarshal(result, &text); err == nil {
+			response.Body = text
+		}
+	}
+
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode the lambda response body")
+		}
+		body = decoded
+	}
+
+	header := http.Header{}
+	for key, value := range response.Headers {
+		header.Set(key, value)
+	}
+	for _, cookie := range response.Cookies {
+		header.Add("Set-Cookie", cookie)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	status := *response.StatusCode
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
@@ -0,0 +1,182 @@
+package origins
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/pkg/errors"
+)
+
+type mockTransport struct {
+	config           *types.MockOrigin
+	workingDirectory string
+	readTimeout      time.Duration
+}
+
+func newMockTransport(config *types.MockOrigin, workingDirectory string, readTimeout time.Duration) *mockTransport {
+	if config == nil {
+		config = &types.MockOrigin{}
+	}
+
+	return &mockTransport{
+		config:           config,
+		workingDirectory: workingDirectory,
+		readTimeout:      readTimeout,
+	}
+}
+
+func (t *mockTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+
+	for _, response := range t.config.Responses {
+		if response.Method != "" && !strings.EqualFold(response.Method, r.Method) {
+			continue
+		}
+
+		if !matchPath(response.Path, r.URL.Path) {
+			continue
+		}
+
+		return t.respond(r, response)
+	}
+
+	if t.config.Directory != "" {
+		return t.respondWithFile(r)
+	}
+
+	return newMockResponse(r, http.StatusNotFound, "", io.NopCloser(strings.NewReader("")), 0), nil
+}
+
+func (t *mockTransport) respond(r *http.Request, response types.MockResponse) (*http.Response, error) {
+	if response.Latency > 0 {
+		if response.Latency > t.readTimeout {
+			time.Sleep(t.readTimeout)
+			return nil, fmt.Errorf("origin didn't respond within %s", t.readTimeout)
+		}
+		time.Sleep(response.Latency)
+	}
+
+	if response.Error == "timeout" {
+		time.Sleep(t.readTimeout)
+		return nil, fmt.Errorf("origin didn't respond within %s", t.readTimeout)
+	}
+
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	var body io.ReadCloser = io.NopCloser(strings.NewReader(response.Body))
+	contentLength := int64(len(response.Body))
+	contentType := ""
+	if response.BodyFile != "" {
+		name := response.BodyFile
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(t.workingDirectory, name)
+		}
+
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open the mock body file")
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "failed to read the mock body file")
+		}
+
+		body = file
+		contentLength = info.Size()
+		contentType = mime.TypeByExtension(filepath.Ext(response.BodyFile))
+	}
+
+	resp := newMockResponse(r, status, contentType, body, contentLength)
+	// the configured headers replace the ones the mock sets by default
+	for _, header := range response.Headers {
+		resp.Header.Del(header.Name)
+	}
+	for _, header := range response.Headers {
+		resp.Header.Add(header.Name, header.Value)
+	}
+
+	return resp, nil
+}
+
+func (t *mockTransport) respondWithFile(r *http.Request) (*http.Response, error) {
+	directory := t.config.Directory
+	if !filepath.IsAbs(directory) {
+		directory = filepath.Join(t.workingDirectory, directory)
+	}
+
+	name := filepath.Join(directory, filepath.FromSlash(filepath.Clean("/"+r.URL.Path)))
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		name = filepath.Join(name, "index.html")
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return newMockResponse(r, http.StatusNotFound, "", io.NopCloser(strings.NewReader("")), 0), nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to read the mock fixture")
+	}
+
+	return newMockResponse(r, http.StatusOK, mime.TypeByExtension(filepath.Ext(name)), file, info.Size()), nil
+}
+
+func newMockResponse(r *http.Request, status int, contentType string, body io.ReadCloser, contentLength int64) *http.Response {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          body,
+		ContentLength: contentLength,
+		Request:       r,
+	}
+
+	resp.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	resp.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	resp.Header.Set("Server", "cloudfront-emulator-mock")
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+
+	return resp
+}
+
+// matchPath reports whether the path matches a pattern that uses * and ? as
+// wildcards the same way behavior path patterns do
+func matchPath(pattern string, path string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+
+	matched, err := regexp.MatchString("^"+expression+"$", path)
+	return err == nil && matched
+}
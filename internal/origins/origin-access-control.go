@@ -0,0 +1,151 @@
+package origins
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/edwardofclt/cloudfront-emulator/internal/headercase"
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	SigningBehaviorAlways     = "always"
+	SigningBehaviorNoOverride = "no-override"
+	SigningBehaviorNever      = "never"
+)
+
+// signatureHeaders are replaced every time a request is signed
+var signatureHeaders = []string{
+	"authorization",
+	"x-amz-content-sha256",
+	"x-amz-date",
+	"x-amz-security-token",
+}
+
+var lambdaURLDomainRegex = regexp.MustCompile(`\.lambda-url\.([a-z0-9-]+)\.on\.aws$`)
+
+// SignatureHeaders returns the headers origin access control adds to the origin
+// request so they can be inspected by origin-request handlers. The request is
+// signed again right before it's sent, so changes made by the handler are
+// reflected in the final signature.
+func SignatureHeaders(config *OriginRequestConfig) (types.CfHeaderArray, error) {
+	headers := types.CfHeaderArray{}
+	if config.Origin.OriginAccessControl == nil {
+		return headers, nil
+	}
+
+	// the viewer body can only be read once, so it isn't part of this signature
+	originRequest, _, err := newOriginRequest(config, http.NoBody, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := signRequest(originRequest, config); err != nil {
+		return nil, err
+	}
+
+	for _, name := range signatureHeaders {
+		if value := originRequest.Header.Get(name); value != "" {
+			headers[name] = []types.CfHeader{
+				{
+					Key:   http.CanonicalHeaderKey(name),
+					Value: value,
+				},
+			}
+		}
+	}
+
+	return headers, nil
+}
+
+// signRequest signs the origin request with SigV4 the way CloudFront does when
+// origin access control is enabled
+func signRequest(r *http.Request, config *OriginRequestConfig) error {
+	oac := config.Origin.OriginAccessControl
+
+	switch oac.SigningBehavior {
+	case SigningBehaviorNever:
+		return nil
+	case SigningBehaviorNoOverride:
+		// the authorization header of the viewer is forwarded as is
+		if config.HTTPRequest.Header.Get("Authorization") != "" {
+			return nil
+		}
+	case "", SigningBehaviorAlways:
+	default:
+		return errors.Errorf("unknown origin access control signing behavior: %s", oac.SigningBehavior)
+	}
+
+	for _, name := range signatureHeaders {
+		headercase.Del(r.Header, name)
+	}
+
+	service := signingService(config.Origin)
+	signer := v4.NewSigner(signingCredentials(oac))
+	signer.UnsignedPayload = service == "s3"
+	signer.DisableRequestBodyOverwrite = true
+
+	var body io.ReadSeeker
+	if r.Body != nil && r.Body != http.NoBody && !signer.UnsignedPayload {
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the body to sign")
+		}
+		r.Body.Close()
+
+		body = bytes.NewReader(content)
+		r.Body = io.NopCloser(bytes.NewReader(content))
+		r.ContentLength = int64(len(content))
+	}
+
+	if _, err := signer.Sign(r, body, service, signingRegion(config.Origin), time.Now()); err != nil {
+		return errors.Wrap(err, "failed to sign the origin request")
+	}
+
+	return nil
+}
+
+func signingCredentials(oac *types.OriginAccessControl) *credentials.Credentials {
+	if oac.AccessKeyID != "" {
+		return credentials.NewStaticCredentials(oac.AccessKeyID, oac.SecretAccessKey, oac.SessionToken)
+	}
+
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvProvider{},
+		&credentials.SharedCredentialsProvider{Profile: oac.Profile},
+	})
+}
+
+func signingService(origin types.Origin) string {
+	if origin.OriginAccessControl.OriginType != "" {
+		return origin.OriginAccessControl.OriginType
+	}
+
+	if origin.Type == types.OriginTypeLambda || lambdaURLDomainRegex.MatchString(origin.Domain) {
+		return "lambda"
+	}
+
+	return "s3"
+}
+
+func signingRegion(origin types.Origin) string {
+	if origin.OriginAccessControl.Region != "" {
+		return origin.OriginAccessControl.Region
+	}
+
+	if matches := s3DomainRegex.FindStringSubmatch(origin.Domain); matches != nil && matches[2] != "" {
+		return matches[2]
+	}
+
+	if matches := lambdaURLDomainRegex.FindStringSubmatch(origin.Domain); matches != nil {
+		return matches[1]
+	}
+
+	return "us-east-1"
+}
@@ -1,79 +1,293 @@
 package origins
 
 import (
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
-	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/edwardofclt/cloudfront-emulator/internal/headercase"
 	"github.com/edwardofclt/cloudfront-emulator/internal/types"
 	"github.com/pkg/errors"
 )
 
 type OriginRequestConfig struct {
-	HTTPRequest *http.Request
-	CfRequest   types.RequestPayload
-	Origin      types.Origin
+	HTTPRequest      *http.Request
+	CfRequest        types.RequestPayload
+	Origin           types.Origin
+	WorkingDirectory string
 }
 
-func Request(config *OriginRequestConfig) (*types.CfResponse, error) {
+var s3DomainRegex = regexp.MustCompile(`^(.+)\.s3[.-]?([a-z0-9-]*)\.amazonaws\.com$`)
+
+// NewCfOrigin generates the origin object that is included in the request for
+// origin-request events
+func NewCfOrigin(origin types.Origin, r *http.Request) *types.CfOrigin {
+	customHeaders := types.CfHeaderArray{}
+	for _, header := range origin.CustomHeaders {
+		key := strings.ToLower(header.Name)
+		customHeaders[key] = append(customHeaders[key], types.CfHeader{
+			Key:   header.Name,
+			Value: header.Value,
+		})
+	}
+
+	if matches := s3DomainRegex.FindStringSubmatch(origin.Domain); matches != nil {
+		region := matches[2]
+		if region == "" {
+			region = "us-east-1"
+		}
+
+		return &types.CfOrigin{
+			S3: &types.CfS3Origin{
+				DomainName:   origin.Domain,
+				Region:       region,
+				AuthMethod:   "none",
+				Path:         origin.Path,
+				CustomHeader: customHeaders,
+			},
+		}
+	}
+
+	protocol := strings.ToLower(strings.Split(r.Proto, "/")[0])
+	port := uint(80)
+	if protocol == "https" {
+		port = 443
+	}
+
+	return &types.CfOrigin{
+		Custom: &types.CfCustomOrigin{
+			CustomHeaders:    &customHeaders,
+			DomainName:       origin.Domain,
+			KeepAliveTimeout: 5,
+			Path:             origin.Path,
+			Port:             port,
+			Protocol:         protocol,
+			ReadTimeout:      30,
+			SSLProtocols:     []string{"TLSv1.2"},
+		},
+	}
+}
+
+func isDefaultPort(protocol string, port uint) bool {
+	return (protocol == "http" && port == 80) || (protocol == "https" && port == 443)
+}
+
+// joinOriginPath prefixes the uri with the origin path the same way CloudFront
+// does, keeping any trailing slash of the uri
+func joinOriginPath(originPath string, uri string) string {
+	originPath = strings.TrimSuffix(originPath, "/")
+	if originPath != "" && !strings.HasPrefix(originPath, "/") {
+		originPath = "/" + originPath
+	}
+
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/" + uri
+	}
+
+	return originPath + uri
+}
+
+// requestBody returns the body that is sent to the origin, a body replaced by
+// one of the handlers was already put in place of the one the viewer sent
+func requestBody(r *http.Request) (io.Reader, int64) {
+	return r.Body, r.ContentLength
+}
+
+// the transports of custom origins are shared by read timeout, so the
+// connections to the origins are reused instead of leaking
+var (
+	customTransports     = map[time.Duration]*http.Transport{}
+	customTransportsLock sync.Mutex
+)
+
+func customTransport(readTimeout time.Duration) *http.Transport {
+	customTransportsLock.Lock()
+	defer customTransportsLock.Unlock()
+
+	if transport, ok := customTransports[readTimeout]; ok {
+		return transport
+	}
+
+	// The body is streamed back to the viewer, so only the time it takes the
+	// origin to start responding is limited
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           headercase.Dial((&net.Dialer{}).DialContext),
+		ResponseHeaderTimeout: readTimeout,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	customTransports[readTimeout] = transport
+	return transport
+}
+
+// newTransport returns the transport used to reach the type of origin that is
+// configured
+func newTransport(config *OriginRequestConfig, readTimeout time.Duration) (http.RoundTripper, error) {
+	var transport http.RoundTripper
+	switch config.Origin.Type {
+	case "", types.OriginTypeCustom:
+		transport = customTransport(readTimeout)
+	case types.OriginTypeMock:
+		transport = newMockTransport(config.Origin.Mock, config.WorkingDirectory, readTimeout)
+	case types.OriginTypeLambda:
+		functionURL, err := newFunctionURLTransport(config.Origin.Lambda, config.WorkingDirectory, readTimeout)
+		if err != nil {
+			return nil, err
+		}
+		transport = functionURL
+	default:
+		return nil, fmt.Errorf("unknown origin type: %s", config.Origin.Type)
+	}
+
+	if config.Origin.Cassette != nil {
+		return newCassetteTransport(transport, config.Origin.Cassette, config.WorkingDirectory)
+	}
+
+	return transport, nil
+}
+
+// newOriginRequest builds the request that is sent to the origin from the final
+// request of the event
+func newOriginRequest(config *OriginRequestConfig, body io.Reader, contentLength int64) (*http.Request, *types.CfOrigin, error) {
+	request := config.CfRequest.Records[0].Cf.Request
+
+	cfOrigin := request.Origin
+	if cfOrigin == nil {
+		cfOrigin = NewCfOrigin(config.Origin, config.HTTPRequest)
+	}
+
 	fullURL := url.URL{
-		Host:   config.Origin.Domain,
-		Path:   filepath.Join(config.Origin.Path, config.CfRequest.Records[0].Cf.Request.URI),
-		Scheme: strings.Split(config.HTTPRequest.Proto, "/")[0],
+		Host:     config.Origin.Domain,
+		Path:     joinOriginPath(config.Origin.Path, request.URI),
+		RawQuery: request.QueryString,
+		Scheme:   strings.Split(config.HTTPRequest.Proto, "/")[0],
 	}
 
-	originRequest, _ := http.NewRequest(config.CfRequest.Records[0].Cf.Request.Method, fullURL.String(), config.HTTPRequest.Body)
+	if cfOrigin.S3 != nil {
+		fullURL.Host = cfOrigin.S3.DomainName
+		fullURL.Path = joinOriginPath(cfOrigin.S3.Path, request.URI)
+	}
 
-	for _, value := range *config.CfRequest.Records[0].Cf.Request.Headers {
-		if len(value) == 0 {
-			continue
+	if cfOrigin.Custom != nil {
+		fullURL.Host = cfOrigin.Custom.DomainName
+		fullURL.Path = joinOriginPath(cfOrigin.Custom.Path, request.URI)
+		fullURL.Scheme = cfOrigin.Custom.Protocol
+		if port := cfOrigin.Custom.Port; port != 0 && !isDefaultPort(fullURL.Scheme, port) {
+			fullURL.Host = fmt.Sprintf("%s:%d", cfOrigin.Custom.DomainName, cfOrigin.Custom.Port)
 		}
-		if originRequest == nil {
-			continue
+	}
+
+	originRequest, err := http.NewRequest(request.Method, fullURL.String(), body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate the origin request")
+	}
+	originRequest.ContentLength = contentLength
+
+	if request.Headers != nil {
+		for key, value := range *request.Headers {
+			for _, header := range value {
+				// the host header can't be set through the header map
+				if strings.ToLower(key) == "host" {
+					originRequest.Host = header.Value
+					continue
+				}
+
+				headercase.Add(originRequest.Header, header.Key, header.Value)
+			}
 		}
+	}
 
-		originRequest.Header.Add(value[0].Key, value[0].Value)
+	// CloudFront overwrites any viewer header that has the same name as one of
+	// the origin's custom headers
+	for key, value := range *cfOrigin.CustomHeaders() {
+		headercase.Del(originRequest.Header, key)
+		for _, header := range value {
+			headercase.Add(originRequest.Header, header.Key, header.Value)
+		}
+	}
+
+	return originRequest, cfOrigin, nil
+}
+
+// Request fetches the object from the origin. The response body isn't read,
+// it's up to the caller to stream it to the viewer and close it.
+func Request(config *OriginRequestConfig) (*types.CfResponse, io.ReadCloser, error) {
+	body, contentLength := requestBody(config.HTTPRequest)
+	originRequest, cfOrigin, err := newOriginRequest(config, body, contentLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.Origin.OriginAccessControl != nil {
+		if err := signRequest(originRequest, config); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	readTimeout := time.Second * 30
+	if cfOrigin.Custom != nil && cfOrigin.Custom.ReadTimeout != 0 {
+		readTimeout = time.Second * time.Duration(cfOrigin.Custom.ReadTimeout)
+	}
+
+	transport, err := newTransport(config, readTimeout)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	client := http.Client{
-		Timeout: time.Second * 5,
+		Transport: transport,
+		// CloudFront hands redirects from the origin back to the viewer
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
 	}
 
+	// the connection records the header names the origin responded with
+	var conn *headercase.Conn
+	originRequest = originRequest.WithContext(httptrace.WithClientTrace(originRequest.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn, _ = info.Conn.(*headercase.Conn)
+		},
+	}))
+
 	originResponse, err := client.Do(originRequest)
 	if err != nil {
-		return nil, errors.Wrap(err, "error while fetching the origin")
+		return nil, nil, errors.Wrap(err, "error while fetching the origin")
 	}
 
-	originResponseData, err := io.ReadAll(originResponse.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "error while parsing origin response")
+	var names map[string]string
+	if conn != nil {
+		names = conn.Names()
 	}
 
 	statusCode := strconv.Itoa(originResponse.StatusCode)
 
 	finalResponse := &types.CfResponse{
 		BaseConfig: types.BaseConfig{
-			Body:    aws.String(string(originResponseData)),
 			Status:  &statusCode,
 			Headers: &types.CfHeaderArray{},
 		},
 	}
 
-	for key, value := range originResponse.Header {
-		header := *finalResponse.Headers
-		header[key] = []types.CfHeader{
-			{
-				Key:   key,
-				Value: value[0],
-			},
+	headers := *finalResponse.Headers
+	for key, values := range originResponse.Header {
+		name := headercase.Original(names, key, originResponse.ProtoMajor)
+		for _, value := range values {
+			headers[strings.ToLower(key)] = append(headers[strings.ToLower(key)], types.CfHeader{
+				Key:   name,
+				Value: value,
+			})
 		}
-		finalResponse.Headers = &header
 	}
 
-	return finalResponse, nil
+	return finalResponse, originResponse.Body, nil
 }
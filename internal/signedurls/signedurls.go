@@ -0,0 +1,460 @@
+package signedurls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the query parameters of signed urls, signed cookies use the same names
+// prefixed with CloudFront-
+const (
+	ParamExpires   = "Expires"
+	ParamPolicy    = "Policy"
+	ParamSignature = "Signature"
+	ParamKeyPairID = "Key-Pair-Id"
+
+	CookiePrefix = "CloudFront-"
+)
+
+var params = []string{ParamExpires, ParamPolicy, ParamSignature, ParamKeyPairID}
+
+// the error codes CloudFront responds with
+const (
+	ErrorMissingKey   = "MissingKey"
+	ErrorAccessDenied = "AccessDenied"
+)
+
+// Error is returned when a request isn't signed properly, CloudFront responds
+// with a 403 when it happens
+type Error struct {
+	Code    string
+	Message string
+	// Reason is why access was denied, CloudFront doesn't tell the viewer
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Code, e.Message, e.Reason)
+}
+
+func accessDenied(format string, args ...interface{}) error {
+	return &Error{Code: ErrorAccessDenied, Message: "Access denied", Reason: fmt.Sprintf(format, args...)}
+}
+
+// Policy is the policy a signed url or cookie grants access with
+type Policy struct {
+	Statement []Statement `json:"Statement"`
+}
+
+type Statement struct {
+	Resource  string    `json:"Resource,omitempty"`
+	Condition Condition `json:"Condition"`
+}
+
+type Condition struct {
+	DateLessThan    *EpochTime `json:"DateLessThan,omitempty"`
+	DateGreaterThan *EpochTime `json:"DateGreaterThan,omitempty"`
+	IPAddress       *SourceIP  `json:"IpAddress,omitempty"`
+}
+
+type EpochTime struct {
+	EpochTime int64 `json:"AWS:EpochTime"`
+}
+
+type SourceIP struct {
+	SourceIP string `json:"AWS:SourceIp"`
+}
+
+// CannedPolicy is the policy of a signed url or cookie that only expires, it's
+// never sent along with the signature
+func CannedPolicy(resource string, expires time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resource, expires.Unix()))
+}
+
+// CustomPolicy is the policy of a signed url or cookie that can also start
+// later and be limited to a range of ips, the zero time and an empty range
+// leave them out
+func CustomPolicy(resource string, expires time.Time, starts time.Time, sourceIP string) ([]byte, error) {
+	statement := Statement{
+		Resource: resource,
+		Condition: Condition{
+			DateLessThan: &EpochTime{EpochTime: expires.Unix()},
+		},
+	}
+
+	if !starts.IsZero() {
+		statement.Condition.DateGreaterThan = &EpochTime{EpochTime: starts.Unix()}
+	}
+
+	if sourceIP != "" {
+		if _, err := parseSourceIP(sourceIP); err != nil {
+			return nil, err
+		}
+		statement.Condition.IPAddress = &SourceIP{SourceIP: sourceIP}
+	}
+
+	return json.Marshal(Policy{Statement: []Statement{statement}})
+}
+
+// Encode is the url safe base64 encoding CloudFront uses for policies and
+// signatures
+func Encode(data []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(data))
+}
+
+// Decode reverses Encode
+func Decode(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(value))
+}
+
+// Sign signs the policy, RSA keys sign with SHA1 and ECDSA keys with SHA256
+// like CloudFront expects
+func Sign(policy []byte, key crypto.Signer) (string, error) {
+	hash := crypto.SHA1
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		hash = crypto.SHA256
+	}
+
+	h := hash.New()
+	h.Write(policy)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign the policy")
+	}
+
+	return Encode(signature), nil
+}
+
+func verifySignature(policy []byte, signature []byte, key crypto.PublicKey) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		digest := sha1.Sum(policy)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA1, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(policy)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	}
+	return false
+}
+
+// SignURL adds the signature of the policy to the url, the policy is left out
+// when it's canned
+func SignURL(rawURL string, policy []byte, canned bool, keyPairID string, key crypto.Signer) (string, error) {
+	signature, err := Sign(policy, key)
+	if err != nil {
+		return "", err
+	}
+
+	values := []string{}
+	if canned {
+		expires, err := policyExpires(policy)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, ParamExpires+"="+strconv.FormatInt(expires, 10))
+	} else {
+		values = append(values, ParamPolicy+"="+Encode(policy))
+	}
+	values = append(values, ParamSignature+"="+signature, ParamKeyPairID+"="+keyPairID)
+
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + strings.Join(values, "&"), nil
+}
+
+// SignCookies returns the signed cookies granting access with the policy, by
+// name
+func SignCookies(policy []byte, canned bool, keyPairID string, key crypto.Signer) (map[string]string, error) {
+	signature, err := Sign(policy, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cookies := map[string]string{
+		CookiePrefix + ParamSignature: signature,
+		CookiePrefix + ParamKeyPairID: keyPairID,
+	}
+
+	if canned {
+		expires, err := policyExpires(policy)
+		if err != nil {
+			return nil, err
+		}
+		cookies[CookiePrefix+ParamExpires] = strconv.FormatInt(expires, 10)
+	} else {
+		cookies[CookiePrefix+ParamPolicy] = Encode(policy)
+	}
+
+	return cookies, nil
+}
+
+func policyExpires(policy []byte) (int64, error) {
+	p := Policy{}
+	if err := json.Unmarshal(policy, &p); err != nil || len(p.Statement) != 1 || p.Statement[0].Condition.DateLessThan == nil {
+		return 0, fmt.Errorf("the policy doesn't expire")
+	}
+	return p.Statement[0].Condition.DateLessThan.EpochTime, nil
+}
+
+// signingValues returns the signing parameters of the request. The query
+// parameters take precedence over the cookies, the way they do on CloudFront.
+func signingValues(r *http.Request) map[string]string {
+	values := map[string]string{}
+	query := r.URL.Query()
+	for _, param := range params {
+		if value := query.Get(param); value != "" {
+			values[param] = value
+		}
+	}
+	if len(values) > 0 {
+		return values
+	}
+
+	for _, param := range params {
+		if cookie, err := r.Cookie(CookiePrefix + param); err == nil && cookie.Value != "" {
+			values[param] = cookie.Value
+		}
+	}
+	return values
+}
+
+// Resource is the url the request is for as it's matched against policies,
+// without the signing parameters
+func Resource(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	query := []string{}
+	for _, part := range strings.Split(r.URL.RawQuery, "&") {
+		name := strings.SplitN(part, "=", 2)[0]
+		if part == "" || isSigningParam(name) {
+			continue
+		}
+		query = append(query, part)
+	}
+
+	resource := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.EscapedPath())
+	if len(query) > 0 {
+		resource += "?" + strings.Join(query, "&")
+	}
+	return resource
+}
+
+func isSigningParam(name string) bool {
+	for _, param := range params {
+		if name == param {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify makes sure the request is signed with one of the keys, by key pair id,
+// and that its policy grants access to the resource
+func Verify(r *http.Request, keys map[string]crypto.PublicKey, clientIP string, now time.Time) error {
+	values := signingValues(r)
+
+	keyPairID := values[ParamKeyPairID]
+	if keyPairID == "" {
+		return &Error{Code: ErrorMissingKey, Message: "Missing Key-Pair-Id query parameter or cookie value"}
+	}
+
+	key, ok := keys[keyPairID]
+	if !ok {
+		return accessDenied("the key %s isn't in the trusted key groups of the behavior", keyPairID)
+	}
+
+	if values[ParamSignature] == "" {
+		return accessDenied("the signature is missing")
+	}
+	signature, err := Decode(values[ParamSignature])
+	if err != nil {
+		return accessDenied("the signature isn't valid base64")
+	}
+
+	resource := Resource(r)
+
+	var policy []byte
+	canned := values[ParamPolicy] == ""
+	switch {
+	case !canned:
+		policy, err = Decode(values[ParamPolicy])
+		if err != nil {
+			return accessDenied("the policy isn't valid base64")
+		}
+	case values[ParamExpires] != "":
+		expires, err := strconv.ParseInt(values[ParamExpires], 10, 64)
+		if err != nil {
+			return accessDenied("invalid expires: %s", values[ParamExpires])
+		}
+		policy = CannedPolicy(resource, time.Unix(expires, 0))
+	default:
+		return accessDenied("either a policy or an expiration time is required")
+	}
+
+	if !verifySignature(policy, signature, key) {
+		return accessDenied("the signature doesn't match the policy")
+	}
+
+	p := Policy{}
+	if err := json.Unmarshal(policy, &p); err != nil {
+		return accessDenied("the policy isn't valid JSON: %s", err)
+	}
+	if len(p.Statement) != 1 {
+		return accessDenied("the policy must have a single statement")
+	}
+
+	return checkStatement(p.Statement[0], resource, clientIP, now)
+}
+
+func checkStatement(statement Statement, resource string, clientIP string, now time.Time) error {
+	// a policy without a resource covers every resource of the distribution
+	if statement.Resource != "" && !matchResource(statement.Resource, resource) {
+		return accessDenied("the policy is for %s, not %s", statement.Resource, resource)
+	}
+
+	condition := statement.Condition
+	if condition.DateLessThan == nil {
+		return accessDenied("the policy doesn't expire")
+	}
+	if now.Unix() >= condition.DateLessThan.EpochTime {
+		return accessDenied("the policy expired at %s", time.Unix(condition.DateLessThan.EpochTime, 0).UTC())
+	}
+
+	if condition.DateGreaterThan != nil && now.Unix() <= condition.DateGreaterThan.EpochTime {
+		return accessDenied("the policy is only valid from %s", time.Unix(condition.DateGreaterThan.EpochTime, 0).UTC())
+	}
+
+	if condition.IPAddress != nil {
+		network, err := parseSourceIP(condition.IPAddress.SourceIP)
+		if err != nil {
+			return accessDenied("%s", err)
+		}
+		if ip := net.ParseIP(clientIP); ip == nil || !network.Contains(ip) {
+			return accessDenied("the policy is for %s, the viewer is %s", condition.IPAddress.SourceIP, clientIP)
+		}
+	}
+
+	return nil
+}
+
+// parseSourceIP parses the ip range of a policy, a single ip is a range of its
+// own
+func parseSourceIP(sourceIP string) (*net.IPNet, error) {
+	if !strings.Contains(sourceIP, "/") {
+		ip := net.ParseIP(sourceIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source ip: %s", sourceIP)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(sourceIP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source ip: %s", sourceIP)
+	}
+	return network, nil
+}
+
+// matchResource matches the resource against the one of a policy, which can
+// use * and ? as wildcards
+func matchResource(pattern string, resource string) bool {
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+	matched, _ := regexp.MatchString("^"+expression+"$", resource)
+	return matched
+}
+
+// LoadPublicKey reads an RSA or ECDSA public key from a PEM file
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the public key %s", file)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("the public key %s isn't an RSA or ECDSA key", file)
+}
+
+// LoadPrivateKey reads an RSA or ECDSA private key from a PEM file
+func LoadPrivateKey(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the private key %s", file)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("the private key %s isn't an RSA or ECDSA key", file)
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the key %s", file)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("the key %s isn't PEM encoded", file)
+	}
+	return block, nil
+}
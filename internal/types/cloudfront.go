@@ -5,6 +5,8 @@ type CloudfrontEventInput struct {
 	CfResponse       *CfResponse
 	FinalResponse    *CfResponse
 	CallbackResponse CallbackResponse
+	// Returned is false when the handler finished without returning anything
+	Returned bool
 }
 
 type CloudfrontEvent interface {
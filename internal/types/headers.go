@@ -1,51 +1,152 @@
 package types
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 type ReadOnlyHeader map[string]struct{}
 
-var AlwaysReadOnlyHeaders = ReadOnlyHeader{
-	"connection":                    {},
-	"expect":                        {},
-	"keep-alive":                    {},
-	"proxy-authenticate":            {},
-	"proxy-authorization":           {},
-	"proxy-connection":              {},
-	"trailer":                       {},
-	"upgrade":                       {},
-	"x-accel-buffering":             {},
-	"x-accel-charset":               {},
-	"x-accel-limit-rate":            {},
-	"x-accel-redirect":              {},
-	"x-amz-cf-(.*)":                 {},
-	"x-amzn-auth":                   {},
-	"x-amzn-cf-billing":             {},
-	"x-amzn-cf-id":                  {},
-	"x-amzn-cf-xff":                 {},
-	"x-amzn-errortype":              {},
-	"x-amzn-fle-profile":            {},
-	"x-amzn-header-count":           {},
-	"x-amzn-header-order":           {},
-	"x-amzn-lambda-integration-tag": {},
-	"x-amzn-requestid":              {},
-	"x-cache":                       {},
-	"x-edge-(.*)":                   {},
-	"x-forwarded-proto":             {},
-	"x-real-ip":                     {},
-}
-
-var ViewerRequestReadOnlyHeaders = ReadOnlyHeader{
-	"content-length":    {},
-	"host":              {},
-	"transfer-encoding": {},
-	"via":               {},
-}
-
-var OriginRequestReadOnlyHeaders = ReadOnlyHeader{
-	"accept-encoding":     {},
+type HeaderRuleType string
+
+const (
+	// HeaderRuleDisallowed headers can't be added, modified or removed by any
+	// handler
+	HeaderRuleDisallowed HeaderRuleType = "disallowed"
+	// HeaderRuleReadOnly headers can't be added, modified or removed by the
+	// handlers of the triggers the rule applies to
+	HeaderRuleReadOnly HeaderRuleType = "read-only"
+)
+
+// HeaderRule restricts what handlers can do with the headers that match its
+// pattern. Patterns are matched against lowercase header names, a trailing *
+// matches by prefix, a pattern between slashes is a regular expression and
+// anything else is an exact match.
+type HeaderRule struct {
+	Type    HeaderRuleType
+	Pattern string
+	// Triggers the rule applies to, all of them when empty
+	Triggers []EventType
+
+	regex *regexp.Regexp
+}
+
+func newHeaderRule(ruleType HeaderRuleType, pattern string, triggers ...EventType) HeaderRule {
+	rule := HeaderRule{
+		Type:     ruleType,
+		Pattern:  pattern,
+		Triggers: triggers,
+	}
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		rule.regex = regexp.MustCompile(pattern[1 : len(pattern)-1])
+	}
+
+	return rule
+}
+
+// Matches reports whether the rule applies to the header for the trigger
+func (r HeaderRule) Matches(eventType EventType, name string) bool {
+	if len(r.Triggers) > 0 {
+		applies := false
+		for _, trigger := range r.Triggers {
+			applies = applies || trigger == eventType
+		}
+		if !applies {
+			return false
+		}
+	}
+
+	name = strings.ToLower(name)
+	switch {
+	case r.regex != nil:
+		return r.regex.MatchString(name)
+	case strings.HasSuffix(r.Pattern, "*"):
+		return strings.HasPrefix(name, strings.TrimSuffix(r.Pattern, "*"))
+	default:
+		return name == r.Pattern
+	}
+}
+
+// HeaderRuleError is returned when a handler breaks one of the header rules,
+// CloudFront responds with a 502 when it happens
+type HeaderRuleError struct {
+	EventType EventType
+	Header    string
+	Rule      HeaderRule
+}
+
+func (e *HeaderRuleError) Error() string {
+	return fmt.Sprintf("%s: the %s header is %s (rule %s) and can't be added, modified or removed", e.EventType, e.Header, e.Rule.Type, e.Rule.Pattern)
+}
+
+// HeaderRules are the restrictions CloudFront documents for the headers of
+// Lambda@Edge functions
+var HeaderRules = []HeaderRule{
+	newHeaderRule(HeaderRuleDisallowed, "connection"),
+	newHeaderRule(HeaderRuleDisallowed, "expect"),
+	newHeaderRule(HeaderRuleDisallowed, "keep-alive"),
+	newHeaderRule(HeaderRuleDisallowed, "proxy-authenticate"),
+	newHeaderRule(HeaderRuleDisallowed, "proxy-authorization"),
+	newHeaderRule(HeaderRuleDisallowed, "proxy-connection"),
+	newHeaderRule(HeaderRuleDisallowed, "trailer"),
+	newHeaderRule(HeaderRuleDisallowed, "upgrade"),
+	newHeaderRule(HeaderRuleDisallowed, "/^x-accel-(buffering|charset|limit-rate|redirect)$/"),
+	newHeaderRule(HeaderRuleDisallowed, "x-amz-cf-*"),
+	newHeaderRule(HeaderRuleDisallowed, "/^x-amzn-(auth|cf-billing|cf-id|cf-xff|errortype|fle-profile|header-count|header-order|lambda-integration-tag|requestid)$/"),
+	newHeaderRule(HeaderRuleDisallowed, "x-cache"),
+	newHeaderRule(HeaderRuleDisallowed, "x-edge-*"),
+	newHeaderRule(HeaderRuleDisallowed, "x-forwarded-proto"),
+	newHeaderRule(HeaderRuleDisallowed, "x-real-ip"),
+
+	newHeaderRule(HeaderRuleReadOnly, "accept-encoding", OriginRequest),
+	newHeaderRule(HeaderRuleReadOnly, "content-encoding", ViewerResponse),
+	newHeaderRule(HeaderRuleReadOnly, "content-length", ViewerRequest, OriginRequest, ViewerResponse),
+	newHeaderRule(HeaderRuleReadOnly, "host", ViewerRequest),
+	newHeaderRule(HeaderRuleReadOnly, "/^if-(modified-since|none-match|range|unmodified-since)$/", OriginRequest),
+	newHeaderRule(HeaderRuleReadOnly, "transfer-encoding"),
+	newHeaderRule(HeaderRuleReadOnly, "via"),
+	newHeaderRule(HeaderRuleReadOnly, "warning", ViewerResponse),
+}
+
+// DisallowedOriginCustomHeaders can't be added to origin requests through an
+// origin's custom headers
+var DisallowedOriginCustomHeaders = ReadOnlyHeader{
+	"cache-control":       {},
+	"connection":          {},
 	"content-length":      {},
+	"cookie":              {},
+	"host":                {},
+	"if-match":            {},
 	"if-modified-since":   {},
 	"if-none-match":       {},
 	"if-range":            {},
 	"if-unmodified-since": {},
+	"max-forwards":        {},
+	"pragma":              {},
+	"proxy-authorization": {},
+	"proxy-connection":    {},
+	"range":               {},
+	"request-range":       {},
+	"te":                  {},
+	"trailer":             {},
 	"transfer-encoding":   {},
+	"upgrade":             {},
 	"via":                 {},
+	"x-real-ip":           {},
 }
+
+// DisallowedOriginCustomHeaderPrefixes can't be used as the start of an
+// origin custom header name
+var DisallowedOriginCustomHeaderPrefixes = []string{
+	"x-amz-",
+	"x-edge-",
+}
+
+const (
+	MaxOriginCustomHeaders           = 10
+	MaxOriginCustomHeaderNameLength  = 256
+	MaxOriginCustomHeaderValueLength = 1783
+	MaxOriginCustomHeadersLength     = 10240
+)
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -50,6 +51,16 @@ func MergeRequestBody(request *CfRequest, respData CfRequest) {
 	return
 }
 
+// ReplaceRequestBody swaps the body of the request for the one returned by the
+// handler when the handler asked for it to be replaced
+func ReplaceRequestBody(request *CfRequest, body *CfRequestBody) {
+	if body == nil || body.Action != BodyActionReplace {
+		return
+	}
+
+	request.Body = body
+}
+
 func MergeResponseBody(request *CfResponse, respData CfResponse) {
 	if respData.URI != request.URI {
 		request.URI = respData.URI
@@ -72,19 +83,54 @@ func SendErrorResponse(w http.ResponseWriter, content, payload string) {
 	fmt.Fprintf(w, `<html><body><h1>502 Error</h1><hr /><p><em>If you're seeing this it means something went wrong executing the logic in your lambda... More context can be found below:</em></p><hr /><pre>%s</pre><hr /><pre>%s</pre></body></html>`, content, payload)
 }
 
-func CheckHeaders(eventType EventType, reqHeaders CfHeaderArray, respHeaders CfHeaderArray) error {
-	for respHeaderKey, header := range respHeaders {
-		if strings.ToLower(header[0].Key) != strings.ToLower(respHeaderKey) {
-			return fmt.Errorf("got %s saw key value %s", header[0].Key, respHeaderKey)
+// CheckHeaders validates the headers returned by the handler of the event type
+// against the headers the handler received
+func CheckHeaders(eventType EventType, before CfHeaderArray, after CfHeaderArray) error {
+	return checkHeaderRules(eventType, before, after, HeaderRuleDisallowed, HeaderRuleReadOnly)
+}
+
+// CheckGeneratedResponseHeaders validates the headers of a response generated
+// by a request handler, only the disallowed headers apply to them
+func CheckGeneratedResponseHeaders(eventType EventType, headers CfHeaderArray) error {
+	return checkHeaderRules(eventType, CfHeaderArray{}, headers, HeaderRuleDisallowed)
+}
+
+func checkHeaderRules(eventType EventType, before CfHeaderArray, after CfHeaderArray, ruleTypes ...HeaderRuleType) error {
+	for key, header := range after {
+		for _, h := range header {
+			if h.Key != "" && !strings.EqualFold(h.Key, key) {
+				return fmt.Errorf("got %s saw key value %s", h.Key, key)
+			}
 		}
 
-		if err := CheckReadOnlyHeader(AlwaysReadOnlyHeaders, header, reqHeaders); err != nil {
-			return errors.Wrap(err, "read only headeres were modified")
+		for _, rule := range HeaderRules {
+			if !includesRuleType(ruleTypes, rule.Type) || !rule.Matches(eventType, key) {
+				continue
+			}
+
+			if !sameHeaderValues(before[strings.ToLower(key)], header) {
+				return &HeaderRuleError{
+					EventType: eventType,
+					Header:    key,
+					Rule:      rule,
+				}
+			}
+		}
+	}
+
+	// removing a header the handler can't change breaks the rules just as well
+	for key := range before {
+		if _, ok := after[key]; ok {
+			continue
 		}
 
-		if eventType == ViewerRequest {
-			if err := CheckReadOnlyHeader(ViewerRequestReadOnlyHeaders, header, reqHeaders); err != nil {
-				return errors.Wrap(err, "read only headeres were modified")
+		for _, rule := range HeaderRules {
+			if includesRuleType(ruleTypes, rule.Type) && rule.Matches(eventType, key) {
+				return &HeaderRuleError{
+					EventType: eventType,
+					Header:    key,
+					Rule:      rule,
+				}
 			}
 		}
 	}
@@ -92,16 +138,152 @@ func CheckHeaders(eventType EventType, reqHeaders CfHeaderArray, respHeaders CfH
 	return nil
 }
 
-func CheckReadOnlyHeader(headerList ReadOnlyHeader, header []CfHeader, reqHeaders CfHeaderArray) error {
-	if _, ok := headerList[header[0].Key]; ok {
-		if reqHeader, ok := reqHeaders[strings.ToLower(header[0].Key)]; ok {
-			if reqHeader[0].Value != header[0].Value {
-				return fmt.Errorf("this header is never allowed to be modified: %s got %s expected value %s", header[0].Key, header[0].Value, reqHeader[0].Value)
+func includesRuleType(ruleTypes []HeaderRuleType, ruleType HeaderRuleType) bool {
+	for _, t := range ruleTypes {
+		if t == ruleType {
+			return true
+		}
+	}
+	return false
+}
+
+func sameHeaderValues(a []CfHeader, b []CfHeader) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckOriginCustomHeaders validates the custom headers of an origin against the
+// restrictions CloudFront places on them
+func CheckOriginCustomHeaders(headers CfHeaderArray) error {
+	count := 0
+	totalLength := 0
+	for key, header := range headers {
+		name := strings.ToLower(key)
+		if _, ok := DisallowedOriginCustomHeaders[name]; ok {
+			return fmt.Errorf("this header can't be used as an origin custom header: %s", key)
+		}
+
+		for _, prefix := range DisallowedOriginCustomHeaderPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return fmt.Errorf("origin custom headers can't start with %s: %s", prefix, key)
+			}
+		}
+
+		if len(key) > MaxOriginCustomHeaderNameLength {
+			return fmt.Errorf("origin custom header name is longer than %d characters: %s", MaxOriginCustomHeaderNameLength, key)
+		}
+
+		for _, h := range header {
+			if strings.ToLower(h.Key) != name {
+				return fmt.Errorf("got %s saw key value %s", h.Key, key)
+			}
+
+			if len(h.Value) > MaxOriginCustomHeaderValueLength {
+				return fmt.Errorf("origin custom header value is longer than %d characters: %s", MaxOriginCustomHeaderValueLength, key)
 			}
-		} else {
-			return fmt.Errorf("this header is never allowed to be modified: %s", header[0].Key)
+
+			count++
+			totalLength += len(h.Key) + len(h.Value)
 		}
 	}
+
+	if count > MaxOriginCustomHeaders {
+		return fmt.Errorf("origins can't have more than %d custom headers, got %d", MaxOriginCustomHeaders, count)
+	}
+
+	if totalLength > MaxOriginCustomHeadersLength {
+		return fmt.Errorf("origin custom headers can't be longer than %d characters in total, got %d", MaxOriginCustomHeadersLength, totalLength)
+	}
+
+	return nil
+}
+
+// BodySizeLimit is the largest body a handler of the event type can see or
+// generate
+func BodySizeLimit(eventType EventType) int {
+	if eventType == ViewerRequest || eventType == ViewerResponse {
+		return MaxViewerGeneratedBodySize
+	}
+
+	return MaxOriginGeneratedBodySize
+}
+
+// DecodeGeneratedBody returns the raw content of a body generated by a handler,
+// which is either text or base64 encoded
+func DecodeGeneratedBody(body string, encoding *string) ([]byte, error) {
+	if encoding == nil || *encoding == BodyEncodingText {
+		return []byte(body), nil
+	}
+
+	if *encoding != BodyEncodingBase64 {
+		return nil, fmt.Errorf("invalid bodyEncoding: %s, expected %s or %s", *encoding, BodyEncodingText, BodyEncodingBase64)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "the body isn't valid base64")
+	}
+
+	return decoded, nil
+}
+
+// NewCfRequestBody exposes the request body to the handlers of the event type,
+// truncating it the same way CloudFront does
+func NewCfRequestBody(eventType EventType, body []byte) *CfRequestBody {
+	truncated := false
+	if limit := BodySizeLimit(eventType); len(body) > limit {
+		body = body[:limit]
+		truncated = true
+	}
+
+	return &CfRequestBody{
+		InputTruncated: truncated,
+		Action:         BodyActionReadOnly,
+		Encoding:       BodyEncodingBase64,
+		Data:           base64.StdEncoding.EncodeToString(body),
+	}
+}
+
+// DecodeRequestBody returns the raw content of a request body
+func DecodeRequestBody(body *CfRequestBody) ([]byte, error) {
+	if body.Encoding == BodyEncodingBase64 {
+		return base64.StdEncoding.DecodeString(body.Data)
+	}
+
+	return []byte(body.Data), nil
+}
+
+// CheckRequestBody validates the body returned by a request handler
+func CheckRequestBody(eventType EventType, body *CfRequestBody) error {
+	if body.Action != BodyActionReadOnly && body.Action != BodyActionReplace {
+		return fmt.Errorf("invalid body action: %s, expected %s or %s", body.Action, BodyActionReadOnly, BodyActionReplace)
+	}
+
+	if body.Action == BodyActionReadOnly {
+		return nil
+	}
+
+	if body.Encoding != BodyEncodingBase64 && body.Encoding != BodyEncodingText {
+		return fmt.Errorf("invalid body encoding: %s, expected %s or %s", body.Encoding, BodyEncodingBase64, BodyEncodingText)
+	}
+
+	data, err := DecodeRequestBody(body)
+	if err != nil {
+		return errors.Wrap(err, "the body isn't valid base64")
+	}
+
+	if limit := BodySizeLimit(eventType); len(data) > limit {
+		return fmt.Errorf("%s replaced the body with %d bytes, the limit is %d bytes", eventType, len(data), limit)
+	}
+
 	return nil
 }
 
@@ -126,6 +308,38 @@ func MergeBaseConfigs(to BaseConfig, from BaseConfig) BaseConfig {
 	return to
 }
 
+// ReplaceRequest applies the request a request handler returned. The uri,
+// querystring and headers are taken as they were returned instead of being
+// merged, so handlers can clear the querystring and remove headers.
+func ReplaceRequest(request *CfRequest, returned BaseConfig) {
+	// the uri can't be cleared, it's only empty when it wasn't returned
+	if returned.URI != "" {
+		request.URI = returned.URI
+	}
+	request.QueryString = returned.QueryString
+
+	// the headers are replaced in place, the payload of the next handler shares them
+	if request.Headers == nil {
+		request.Headers = &CfHeaderArray{}
+	}
+	ReplaceHeaders(*request.Headers, returned.Headers)
+}
+
+// ReplaceHeaders replaces the headers in place by the ones a handler returned,
+// so the headers it removed are gone
+func ReplaceHeaders(headers CfHeaderArray, returned *CfHeaderArray) {
+	for name := range headers {
+		delete(headers, name)
+	}
+	if returned == nil {
+		return
+	}
+
+	for name, values := range *returned {
+		headers[name] = append([]CfHeader{}, values...)
+	}
+}
+
 func MergeHeaders(to *CfHeaderArray, from *CfHeaderArray) {
 	if to == nil {
 		to = &CfHeaderArray{}
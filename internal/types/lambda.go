@@ -1,11 +1,63 @@
 package types
 
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
 type CallbackResponse struct {
-	// TODO: bodyEncoding string `json:"bodyEncoding"`
 	// Body              *string        `json:"body,omitempty"`
 	// Headers           *CfHeaderArray `json:"headers,omitempty"`
 	// Status            *string        `json:"status,omitempty"`
 	// StatusDescription *string        `json:"statusDescription,omitempty"`
 	BaseConfig
 	CfResponse
+	Origin       *CfOrigin `json:"origin,omitempty"`
+	BodyEncoding *string   `json:"bodyEncoding,omitempty"`
+
+	// RequestBody is set when a request handler returns the body as an object
+	// instead of a string
+	RequestBody *CfRequestBody `json:"-"`
+}
+
+// UnmarshalJSON accepts the body both as the string used by generated responses
+// and as the object used by requests
+func (c *CallbackResponse) UnmarshalJSON(data []byte) error {
+	type callbackResponse CallbackResponse
+	content := struct {
+		*callbackResponse
+		Body json.RawMessage `json:"body,omitempty"`
+	}{
+		callbackResponse: (*callbackResponse)(c),
+	}
+
+	if err := json.Unmarshal(data, &content); err != nil {
+		return err
+	}
+
+	body := bytes.TrimSpace(content.Body)
+	if len(body) == 0 || bytes.Equal(body, []byte("null")) {
+		return nil
+	}
+
+	if body[0] == '{' {
+		c.RequestBody = &CfRequestBody{}
+		return json.Unmarshal(body, c.RequestBody)
+	}
+
+	c.BaseConfig.Body = new(string)
+	return json.Unmarshal(body, c.BaseConfig.Body)
 }
+
+const (
+	// MaxViewerGeneratedBodySize is the largest body viewer triggers can generate
+	MaxViewerGeneratedBodySize = 40 * 1024
+	// MaxOriginGeneratedBodySize is the largest body origin triggers can generate
+	MaxOriginGeneratedBodySize = 1024 * 1024
+
+	// MaxViewerTimeout is the longest viewer triggers can run
+	MaxViewerTimeout = 5 * time.Second
+	// MaxOriginTimeout is the longest origin triggers can run
+	MaxOriginTimeout = 30 * time.Second
+)
@@ -0,0 +1,110 @@
+package types
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	// MaxURLLength is the longest uri, including the querystring, CloudFront
+	// accepts
+	MaxURLLength = 8192
+	// MaxRequestHeadersSize is the largest total size of the request headers
+	MaxRequestHeadersSize = 20480
+	// MaxHeaderFieldSize is the largest size of a single request header
+	MaxHeaderFieldSize = 10240
+)
+
+const (
+	// QuotaModeStrict fails requests that exceed a quota like CloudFront does
+	QuotaModeStrict = "strict"
+	// QuotaModeWarn only logs the quotas that are exceeded
+	QuotaModeWarn = "warn"
+)
+
+// QuotaError is returned when a request or a response exceeds one of the
+// quotas of CloudFront
+type QuotaError struct {
+	// EventType is the trigger whose handler exceeded the quota, it's empty
+	// when the viewer did
+	EventType EventType
+	Quota     string
+	Limit     int
+	Size      int
+	// Status is the status code CloudFront responds with
+	Status int
+}
+
+func (e *QuotaError) Error() string {
+	source := "the viewer request"
+	if e.EventType != "" {
+		source = fmt.Sprintf("the output of the %s handler", e.EventType)
+	}
+	return fmt.Sprintf("%s exceeds the %s quota: %d bytes, the limit is %d bytes", source, e.Quota, e.Size, e.Limit)
+}
+
+// CheckRequestQuotas measures the request as it was sent by the viewer, when
+// eventType is empty, or as it was returned by the handler of the event type
+func CheckRequestQuotas(eventType EventType, request *CfRequest) error {
+	uriStatus, headersStatus := http.StatusRequestURITooLong, 494
+	if eventType != "" {
+		uriStatus, headersStatus = http.StatusBadGateway, http.StatusBadGateway
+	}
+
+	urlLength := len(request.URI)
+	if request.QueryString != "" {
+		urlLength += len(request.QueryString) + 1
+	}
+	if urlLength > MaxURLLength {
+		return &QuotaError{EventType: eventType, Quota: "url length", Limit: MaxURLLength, Size: urlLength, Status: uriStatus}
+	}
+
+	if request.Headers == nil {
+		return nil
+	}
+
+	for _, header := range *request.Headers {
+		for _, h := range header {
+			if size := headerSize(h); size > MaxHeaderFieldSize {
+				return &QuotaError{EventType: eventType, Quota: fmt.Sprintf("%s header size", h.Key), Limit: MaxHeaderFieldSize, Size: size, Status: headersStatus}
+			}
+		}
+	}
+
+	if size := HeadersSize(*request.Headers); size > MaxRequestHeadersSize {
+		return &QuotaError{EventType: eventType, Quota: "total header size", Limit: MaxRequestHeadersSize, Size: size, Status: headersStatus}
+	}
+
+	return nil
+}
+
+// CheckResponseQuota measures a response generated by the handler of the event
+// type, headers included
+func CheckResponseQuota(eventType EventType, headers *CfHeaderArray, body []byte) error {
+	size := len(body)
+	if headers != nil {
+		size += HeadersSize(*headers)
+	}
+
+	if limit := BodySizeLimit(eventType); size > limit {
+		return &QuotaError{EventType: eventType, Quota: "generated response size", Limit: limit, Size: size, Status: http.StatusBadGateway}
+	}
+
+	return nil
+}
+
+// HeadersSize is the size the headers take on the wire
+func HeadersSize(headers CfHeaderArray) int {
+	size := 0
+	for _, header := range headers {
+		for _, h := range header {
+			size += headerSize(h)
+		}
+	}
+	return size
+}
+
+func headerSize(h CfHeader) int {
+	// name: value\r\n
+	return len(h.Key) + len(h.Value) + 4
+}
@@ -2,7 +2,9 @@ package types
 
 import (
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -34,9 +36,27 @@ type BaseConfig struct {
 
 type CfRequest struct {
 	BaseConfig
-	Origin *CfOrigin `json:"origin,omitempty"`
+	Body   *CfRequestBody `json:"body,omitempty"`
+	Origin *CfOrigin      `json:"origin,omitempty"`
 }
 
+// CfRequestBody is the request body as it's exposed to, and returned by, the
+// request handlers
+type CfRequestBody struct {
+	InputTruncated bool   `json:"inputTruncated"`
+	Action         string `json:"action,omitempty"`
+	Encoding       string `json:"encoding,omitempty"`
+	Data           string `json:"data"`
+}
+
+const (
+	BodyActionReadOnly = "read-only"
+	BodyActionReplace  = "replace"
+
+	BodyEncodingBase64 = "base64"
+	BodyEncodingText   = "text"
+)
+
 type CfHeaderArray map[string][]CfHeader
 
 type CfResponse struct {
@@ -48,6 +68,25 @@ type CfOrigin struct {
 	S3     *CfS3Origin     `json:"s3,omitempty"`
 }
 
+// CustomHeaders returns the custom headers of whichever origin type is set
+func (o *CfOrigin) CustomHeaders() *CfHeaderArray {
+	if o.S3 != nil {
+		if o.S3.CustomHeader == nil {
+			o.S3.CustomHeader = CfHeaderArray{}
+		}
+		return &o.S3.CustomHeader
+	}
+
+	if o.Custom != nil {
+		if o.Custom.CustomHeaders == nil {
+			o.Custom.CustomHeaders = &CfHeaderArray{}
+		}
+		return o.Custom.CustomHeaders
+	}
+
+	return &CfHeaderArray{}
+}
+
 type CfS3Origin struct {
 	DomainName   string        `json:"domainName,omitempty"`
 	Region       string        `json:"region,omitempty"`
@@ -69,7 +108,7 @@ type CfCustomOrigin struct {
 
 type CfHeader struct {
 	Key   string `json:"key,omitempty"`
-	Value string `json:"value,omitempty"`
+	Value string `json:"value"`
 }
 
 type CfType struct {
@@ -87,16 +126,220 @@ type Cloudfront struct {
 }
 
 type CloudfrontConfig struct {
-	Address          *string           `mapstructure:"address"`
-	Port             *int              `mapstructure:"port"`
-	OriginConfigs    map[string]Origin `mapstructure:"origins"`
-	Behaviors        []Behavior        `mapstructure:"behaviors"`
-	WorkingDirectory string
+	Address *string `mapstructure:"address"`
+	Port    *int    `mapstructure:"port"`
+	// HTTPSPort listens for HTTPS next to the HTTP port, the port only serves
+	// HTTPS when it's 443
+	HTTPSPort     *int              `mapstructure:"httpsPort"`
+	OriginConfigs map[string]Origin `mapstructure:"origins"`
+	Behaviors     []Behavior        `mapstructure:"behaviors"`
+	Geo           GeoConfig         `mapstructure:"geo"`
+	// GeoRestriction blocks viewers by the country of their geo profile
+	GeoRestriction GeoRestriction `mapstructure:"geoRestriction"`
+	// TrustedProxies are the ips and CIDR ranges of reverse proxies in front of
+	// the emulator, the client ip of their requests comes from X-Forwarded-For
+	TrustedProxies []string `mapstructure:"trustedProxies"`
+	// QuotaMode is either strict, the default, or warn to only log the
+	// CloudFront quotas that are exceeded
+	QuotaMode string `mapstructure:"quotaMode"`
+	// Verbose adds the details of failures to the error pages, they're
+	// always logged
+	Verbose bool `mapstructure:"verbose"`
+	// DefaultRootObject is the object requests for the root of the
+	// distribution are for, subdirectories aren't affected
+	DefaultRootObject string `mapstructure:"defaultRootObject"`
+	// WebACL is a rules file emulating the WAF web ACL of the distribution
+	WebACL string `mapstructure:"webAcl"`
+	// KeyGroups are the public keys signed urls and cookies are verified with,
+	// by key group name
+	KeyGroups map[string][]PublicKey `mapstructure:"keyGroups"`
+	// CustomErrorResponses replace the errors of the origins with pages
+	// fetched through the distribution
+	CustomErrorResponses []CustomErrorResponse `mapstructure:"customErrorResponses"`
+	WorkingDirectory     string
+}
+
+// PublicKey is a key of a key group, File is a PEM encoded RSA or ECDSA public
+// key
+type PublicKey struct {
+	ID   string
+	File string
+}
+
+// CustomErrorResponse replaces the responses of the origins with the error code
+// by the page at ResponsePagePath
+type CustomErrorResponse struct {
+	ErrorCode int `mapstructure:"errorCode"`
+	// ResponsePagePath is fetched through the behavior that matches it, the
+	// error of the origin is cached either way
+	ResponsePagePath string `mapstructure:"responsePagePath"`
+	// ResponseCode defaults to the error code
+	ResponseCode int `mapstructure:"responseCode"`
+	// ErrorCachingMinTTL is how long the error of the origin is cached, it
+	// defaults to 10 seconds like it does on CloudFront
+	ErrorCachingMinTTL *time.Duration `mapstructure:"errorCachingMinTtl"`
+}
+
+// DefaultErrorCachingMinTTL is how long CloudFront caches the errors of the
+// origins when the custom error response doesn't say
+const DefaultErrorCachingMinTTL = 10 * time.Second
+
+// CachingTTL is how long the error of the origin is cached
+func (c CustomErrorResponse) CachingTTL() time.Duration {
+	if c.ErrorCachingMinTTL == nil {
+		return DefaultErrorCachingMinTTL
+	}
+	return *c.ErrorCachingMinTTL
+}
+
+// GeoConfig describes where viewers are located for the CloudFront-Viewer-*
+// headers
+type GeoConfig struct {
+	// Default is the name of the profile used when the request doesn't pick one
+	Default  string
+	Profiles map[string]GeoProfile
+	// Database is a MaxMind City database used to locate viewers from their
+	// ip, the default profile is used for the ips it doesn't know about
+	Database string
+	// ASNDatabase is a MaxMind ASN database
+	ASNDatabase string `mapstructure:"asnDatabase"`
+}
+
+const (
+	GeoRestrictionNone      = "none"
+	GeoRestrictionWhitelist = "whitelist"
+	GeoRestrictionBlacklist = "blacklist"
+)
+
+type GeoRestriction struct {
+	// RestrictionType is either none (default), whitelist or blacklist
+	RestrictionType string `mapstructure:"restrictionType"`
+	// Locations are ISO 3166-1 alpha-2 country codes
+	Locations []string
+}
+
+// Blocks reports whether viewers from the country are blocked, viewers whose
+// country isn't known never are
+func (g GeoRestriction) Blocks(country string) bool {
+	if country == "" {
+		return false
+	}
+
+	listed := false
+	for _, location := range g.Locations {
+		listed = listed || strings.EqualFold(location, country)
+	}
+
+	switch g.RestrictionType {
+	case GeoRestrictionWhitelist:
+		return !listed
+	case GeoRestrictionBlacklist:
+		return listed
+	}
+	return false
+}
+
+type GeoProfile struct {
+	Country           string
+	CountryName       string `mapstructure:"countryName"`
+	CountryRegion     string `mapstructure:"countryRegion"`
+	CountryRegionName string `mapstructure:"countryRegionName"`
+	City              string
+	PostalCode        string `mapstructure:"postalCode"`
+	MetroCode         string `mapstructure:"metroCode"`
+	TimeZone          string `mapstructure:"timeZone"`
+	Latitude          string
+	Longitude         string
+	ASN               string `mapstructure:"asn"`
 }
 
 type Origin struct {
-	Domain string
-	Path   string
+	Type          string
+	Domain        string
+	Path          string
+	CustomHeaders []OriginCustomHeader `mapstructure:"customHeaders"`
+	Mock          *MockOrigin
+	Lambda        *LambdaOrigin
+	Cassette      *OriginCassette
+	// OriginAccessControl signs the requests sent to the origin with SigV4
+	OriginAccessControl *OriginAccessControl `mapstructure:"originAccessControl"`
+}
+
+const (
+	OriginTypeCustom = "custom"
+	OriginTypeMock   = "mock"
+	OriginTypeLambda = "lambda"
+)
+
+// OriginCustomHeader is a header CloudFront adds to every request it sends to
+// the origin
+type OriginCustomHeader struct {
+	Name  string
+	Value string
+}
+
+// MockOrigin scripts the responses of an origin so the emulator can run without
+// network access
+type MockOrigin struct {
+	// Directory is searched for a file matching the uri when none of the
+	// responses match the request
+	Directory string
+	Responses []MockResponse
+}
+
+type MockResponse struct {
+	// Path supports * as a wildcard the same way behaviors do
+	Path     string
+	Method   string
+	Status   int
+	Headers  []MockHeader
+	Body     string
+	BodyFile string `mapstructure:"bodyFile"`
+	Latency  time.Duration
+	// Error makes the request to the origin fail with the given message,
+	// "timeout" waits for the origin read timeout before failing
+	Error string
+}
+
+type MockHeader struct {
+	Name  string
+	Value string
+}
+
+// LambdaOrigin stands in for a lambda function URL or an API Gateway HTTP API
+// by invoking a local regional handler with the version 2.0 event format
+type LambdaOrigin struct {
+	Path    string
+	Handler string
+	// Runtime is either nodejs (default) or python
+	Runtime string
+}
+
+type OriginAccessControl struct {
+	// SigningBehavior is either always (default), no-override or never
+	SigningBehavior string `mapstructure:"signingBehavior"`
+	// OriginType is the service the signature is for, it defaults to lambda
+	// for lambda origins and s3 for everything else
+	OriginType string `mapstructure:"originType"`
+	Region     string
+	// The credentials of the environment or the shared credentials file are
+	// used when no access key is configured
+	AccessKeyID     string `mapstructure:"accessKeyId"`
+	SecretAccessKey string `mapstructure:"secretAccessKey"`
+	SessionToken    string `mapstructure:"sessionToken"`
+	Profile         string
+}
+
+// OriginCassette records the responses of the origin to a file and replays them
+// on later requests
+type OriginCassette struct {
+	File string
+	// Mode is either auto, record or replay. auto replays recorded responses
+	// and records the ones that are missing.
+	Mode string
+	// MatchHeaders are the request headers, besides the method and url, that
+	// identify a recording
+	MatchHeaders []string `mapstructure:"matchHeaders"`
 }
 
 type EventType string
@@ -116,16 +359,98 @@ var EventTypes []EventType = []EventType{
 }
 
 type Behavior struct {
-	DefaultPath string `mapstructure:"defaultPath"`
-	Path        string
-	Origin      string
-	Events      map[EventType]Event
+	DefaultPath         string `mapstructure:"defaultPath"`
+	Path                string
+	Origin              string
+	Events              map[EventType]Event
+	OriginRequestPolicy OriginRequestPolicy `mapstructure:"originRequestPolicy"`
+	// ViewerProtocolPolicy is either allow-all (default), redirect-to-https or
+	// https-only
+	ViewerProtocolPolicy string `mapstructure:"viewerProtocolPolicy"`
+	// AllowedMethods defaults to all the methods CloudFront supports
+	AllowedMethods []string `mapstructure:"allowedMethods"`
+	// CachedMethods defaults to GET and HEAD
+	CachedMethods []string `mapstructure:"cachedMethods"`
+	// TrustedKeyGroups require the requests to be signed by a key of one of
+	// the key groups
+	TrustedKeyGroups []string `mapstructure:"trustedKeyGroups"`
+	// Compress compresses the responses with gzip or Brotli when the viewer
+	// accepts them
+	Compress bool `mapstructure:"compress"`
+}
+
+// AllMethods are the methods CloudFront supports
+var AllMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodPost,
+	http.MethodDelete,
+}
+
+// Allows reports whether the behavior accepts requests with the method
+func (b Behavior) Allows(method string) bool {
+	allowed := b.AllowedMethods
+	if len(allowed) == 0 {
+		allowed = AllMethods
+	}
+	return containsMethod(AllMethods, method) && containsMethod(allowed, method)
+}
+
+// Caches reports whether the behavior caches the responses to the method
+func (b Behavior) Caches(method string) bool {
+	cached := b.CachedMethods
+	if len(cached) == 0 {
+		cached = []string{http.MethodGet, http.MethodHead}
+	}
+	return containsMethod(cached, method) && b.Allows(method)
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ViewerProtocolPolicyAllowAll        = "allow-all"
+	ViewerProtocolPolicyRedirectToHTTPS = "redirect-to-https"
+	ViewerProtocolPolicyHTTPSOnly       = "https-only"
+)
+
+// OriginRequestPolicy picks the headers CloudFront generates that are included
+// in the origin request, including the host header of the viewer
+type OriginRequestPolicy struct {
+	Headers []string
+}
+
+// Includes reports whether the policy includes the header
+func (p OriginRequestPolicy) Includes(name string) bool {
+	for _, header := range p.Headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
 }
 
 type Event struct {
 	Path     string
 	Handler  string
 	OnChange []string
+	// IncludeBody exposes the request body to viewer-request and
+	// origin-request handlers
+	IncludeBody bool `mapstructure:"includeBody"`
+	// Timeout defaults to the longest CloudFront allows for the trigger
+	Timeout time.Duration
+	// ReservedConcurrency throttles the handler when it's already running as
+	// many times, it isn't limited when it's 0
+	ReservedConcurrency int `mapstructure:"reservedConcurrency"`
 }
 
 type EventResponse struct {
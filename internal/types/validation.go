@@ -0,0 +1,284 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LambdaValidationError is returned when a handler returns something CloudFront
+// doesn't accept, CloudFront responds with a 502 when it happens
+type LambdaValidationError struct {
+	EventType EventType
+	Reason    string
+}
+
+func (e *LambdaValidationError) Error() string {
+	return fmt.Sprintf("the %s handler returned an invalid object: %s", e.EventType, e.Reason)
+}
+
+type fieldValidator func(eventType EventType, value json.RawMessage) error
+
+var requestFields = map[string]fieldValidator{
+	"body":        validateRequestBodyField,
+	"clientIp":    validateStringField,
+	"headers":     validateHeadersField,
+	"method":      validateStringField,
+	"origin":      validateOriginField,
+	"querystring": validateQueryStringField,
+	"uri":         validateURIField,
+}
+
+var responseFields = map[string]fieldValidator{
+	"body":              validateStringField,
+	"bodyEncoding":      validateBodyEncodingField,
+	"headers":           validateHeadersField,
+	"status":            validateStatusField,
+	"statusDescription": validateStringField,
+}
+
+// ValidateRequestCallback checks what a request handler returned against the
+// request it received
+func ValidateRequestCallback(eventType EventType, config CloudfrontEventInput) error {
+	response := config.CallbackResponse
+	if response.Status != nil {
+		if response.Headers != nil {
+			if err := CheckGeneratedResponseHeaders(eventType, *response.Headers); err != nil {
+				return err
+			}
+		}
+	} else if config.Returned {
+		// the headers of a returned request replace the ones it received
+		after := CfHeaderArray{}
+		if response.Headers != nil {
+			after = *response.Headers
+		}
+
+		before := CfHeaderArray{}
+		if config.CfRequest.Headers != nil {
+			before = *config.CfRequest.Headers
+		}
+
+		if err := CheckHeaders(eventType, before, after); err != nil {
+			return err
+		}
+	}
+
+	if err := CheckReadOnlyRequestFields(eventType, *config.CfRequest, response.BaseConfig); err != nil {
+		return err
+	}
+
+	if response.RequestBody != nil {
+		if err := CheckRequestBody(eventType, response.RequestBody); err != nil {
+			return errors.Wrap(err, "invalid request body")
+		}
+	}
+
+	if response.Origin != nil {
+		if err := CheckOriginCustomHeaders(*response.Origin.CustomHeaders()); err != nil {
+			return errors.Wrap(err, "invalid origin custom headers")
+		}
+	}
+
+	return nil
+}
+
+// ValidateResponseCallback checks what a response handler returned against the
+// response it received
+func ValidateResponseCallback(eventType EventType, config CloudfrontEventInput) error {
+	if !config.Returned {
+		return nil
+	}
+
+	// the headers of a returned response replace the ones it received
+	after := CfHeaderArray{}
+	if config.CallbackResponse.Headers != nil {
+		after = *config.CallbackResponse.Headers
+	}
+
+	before := CfHeaderArray{}
+	if config.CfResponse.Headers != nil {
+		before = *config.CfResponse.Headers
+	}
+
+	return CheckHeaders(eventType, before, after)
+}
+
+// CheckReadOnlyRequestFields makes sure the handler didn't change the fields of
+// the request that are read-only
+func CheckReadOnlyRequestFields(eventType EventType, request CfRequest, response BaseConfig) error {
+	if response.Method != "" && response.Method != request.Method {
+		return &LambdaValidationError{EventType: eventType, Reason: fmt.Sprintf("the method is read-only, it was changed from %s to %s", request.Method, response.Method)}
+	}
+
+	if response.ClientIP != "" && response.ClientIP != request.ClientIP {
+		return &LambdaValidationError{EventType: eventType, Reason: fmt.Sprintf("the clientIp is read-only, it was changed from %s to %s", request.ClientIP, response.ClientIP)}
+	}
+
+	return nil
+}
+
+// ValidateCallback checks the structure of the object a handler returned. Request
+// triggers either return the request or generate a response, which is told
+// apart by the status field, and response triggers return the response.
+func ValidateCallback(eventType EventType, data []byte) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &LambdaValidationError{
+			EventType: eventType,
+			Reason:    fmt.Sprintf(format, args...),
+		}
+	}
+
+	fields := map[string]json.RawMessage{}
+	if !isJSONObject(data) {
+		return invalid("the output isn't a JSON object")
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return invalid("the output isn't valid JSON: %s", err)
+	}
+
+	kind := "response"
+	validators := responseFields
+	isRequestEvent := eventType == ViewerRequest || eventType == OriginRequest
+	if _, ok := fields["status"]; isRequestEvent && !ok {
+		kind = "request"
+		validators = requestFields
+	}
+
+	for name, value := range fields {
+		validate, ok := validators[name]
+		// only origin-request handlers get to change the origin
+		if !ok || (name == "origin" && eventType != OriginRequest) {
+			return invalid("the %s has an unexpected field: %s", kind, name)
+		}
+
+		if err := validate(eventType, value); err != nil {
+			return invalid("invalid %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+func isJSONObject(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+func validateStringField(_ EventType, value json.RawMessage) error {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return fmt.Errorf("expected a string, got %s", value)
+	}
+	return nil
+}
+
+func validateStatusField(_ EventType, value json.RawMessage) error {
+	var status string
+	if err := json.Unmarshal(value, &status); err != nil {
+		return fmt.Errorf("expected a string, got %s", value)
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 200 || code > 599 {
+		return fmt.Errorf("expected a status code between 200 and 599, got %q", status)
+	}
+	return nil
+}
+
+func validateHeadersField(_ EventType, value json.RawMessage) error {
+	headers := map[string][]map[string]json.RawMessage{}
+	if err := json.Unmarshal(value, &headers); err != nil {
+		return fmt.Errorf("headers must look like {\"name\": [{\"key\": \"Name\", \"value\": \"value\"}]}")
+	}
+
+	for name, values := range headers {
+		if name != strings.ToLower(name) {
+			return fmt.Errorf("header names must be lowercase, got %s", name)
+		}
+
+		for _, header := range values {
+			for field := range header {
+				if field != "key" && field != "value" {
+					return fmt.Errorf("the %s header has an unexpected field: %s", name, field)
+				}
+			}
+
+			var headerValue string
+			if err := json.Unmarshal(header["value"], &headerValue); err != nil {
+				return fmt.Errorf("the value of the %s header must be a string", name)
+			}
+
+			if key, ok := header["key"]; ok {
+				var headerKey string
+				if err := json.Unmarshal(key, &headerKey); err != nil {
+					return fmt.Errorf("the key of the %s header must be a string", name)
+				}
+
+				if strings.ToLower(headerKey) != name {
+					return fmt.Errorf("the key %s doesn't match the header name %s", headerKey, name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateURIField(_ EventType, value json.RawMessage) error {
+	var uri string
+	if err := json.Unmarshal(value, &uri); err != nil {
+		return fmt.Errorf("expected a string, got %s", value)
+	}
+
+	if !strings.HasPrefix(uri, "/") {
+		return fmt.Errorf("the uri must start with /, got %q", uri)
+	}
+	return nil
+}
+
+func validateQueryStringField(_ EventType, value json.RawMessage) error {
+	var querystring string
+	if err := json.Unmarshal(value, &querystring); err != nil {
+		return fmt.Errorf("expected a string, got %s", value)
+	}
+
+	if strings.HasPrefix(querystring, "?") {
+		return fmt.Errorf("the querystring can't start with ?, got %q", querystring)
+	}
+
+	if strings.ContainsAny(querystring, "# ") {
+		return fmt.Errorf("the querystring can't contain a fragment or spaces, got %q", querystring)
+	}
+
+	if _, err := url.ParseQuery(querystring); err != nil {
+		return fmt.Errorf("the querystring isn't valid: %s", err)
+	}
+	return nil
+}
+
+func validateRequestBodyField(_ EventType, value json.RawMessage) error {
+	if !isJSONObject(value) {
+		return fmt.Errorf("the request body must be an object, got %s", value)
+	}
+	return nil
+}
+
+func validateOriginField(_ EventType, value json.RawMessage) error {
+	if !isJSONObject(value) {
+		return fmt.Errorf("the origin must be an object, got %s", value)
+	}
+	return nil
+}
+
+func validateBodyEncodingField(_ EventType, value json.RawMessage) error {
+	var encoding string
+	if err := json.Unmarshal(value, &encoding); err != nil || (encoding != BodyEncodingText && encoding != BodyEncodingBase64) {
+		return fmt.Errorf("expected %s or %s, got %s", BodyEncodingText, BodyEncodingBase64, value)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package types
+
+import "time"
+
+// the actions of WAF rules
+const (
+	WAFActionAllow = "allow"
+	WAFActionBlock = "block"
+	WAFActionCount = "count"
+)
+
+// the positional constraints of WAF string matches
+const (
+	WAFConstraintExactly      = "EXACTLY"
+	WAFConstraintStartsWith   = "STARTS_WITH"
+	WAFConstraintEndsWith     = "ENDS_WITH"
+	WAFConstraintContains     = "CONTAINS"
+	WAFConstraintContainsWord = "CONTAINS_WORD"
+)
+
+// the text transformations of WAF string matches
+const (
+	WAFTransformationLowercase = "LOWERCASE"
+	WAFTransformationURLDecode = "URL_DECODE"
+)
+
+// WebACL emulates the WAF web ACL of the distribution, it's evaluated before
+// the viewer-request event
+type WebACL struct {
+	// DefaultAction is either allow (default) or block
+	DefaultAction string `mapstructure:"defaultAction"`
+	// Rules are evaluated in order until one allows or blocks the request
+	Rules []WAFRule
+	// BlockResponse replaces the 403 of blocked requests, the custom error
+	// page for 403s is used when it's not set
+	BlockResponse *WAFResponse `mapstructure:"blockResponse"`
+}
+
+// WAFRule matches the requests that match all of its statements
+type WAFRule struct {
+	Name string
+	// Action is either allow, block or count
+	Action string
+	// IPSet matches the client ip against ips and CIDR ranges
+	IPSet []string `mapstructure:"ipSet"`
+	// GeoMatch matches the country of the viewer
+	GeoMatch []string `mapstructure:"geoMatch"`
+	// StringMatch matches a part of the request
+	StringMatch *WAFStringMatch `mapstructure:"stringMatch"`
+	// RateLimit matches the clients that sent more requests matching the
+	// other statements than the limit
+	RateLimit *WAFRateLimit `mapstructure:"rateLimit"`
+	// BlockResponse replaces the block response of the web ACL
+	BlockResponse *WAFResponse `mapstructure:"blockResponse"`
+}
+
+type WAFStringMatch struct {
+	// Field is uri, querystring, method or header:<name>
+	Field string
+	// PositionalConstraint defaults to CONTAINS
+	PositionalConstraint string `mapstructure:"positionalConstraint"`
+	SearchString         string `mapstructure:"searchString"`
+	// TextTransformations are applied to the field before it's matched,
+	// LOWERCASE and URL_DECODE are supported
+	TextTransformations []string `mapstructure:"textTransformations"`
+}
+
+type WAFRateLimit struct {
+	// Limit is how many requests a client ip can send in the window
+	Limit int
+	// Window defaults to 5 minutes like it does on WAF
+	Window time.Duration
+}
+
+// WAFResponse is a custom response to blocked requests
+type WAFResponse struct {
+	// Status defaults to 403
+	Status  int
+	Headers []MockHeader
+	Body    string
+}
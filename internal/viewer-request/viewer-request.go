@@ -12,23 +12,15 @@ func New() types.CloudfrontEvent {
 }
 
 func (e *ViewerRequestEvent) Execute(config types.CloudfrontEventInput) error {
-	err := validateRequest(types.ViewerRequest, *config.CfRequest, *config.CfRequest)
+	err := types.ValidateRequestCallback(types.ViewerRequest, config)
 	if err != nil {
 		return err
 	}
 
-	config.CfRequest.BaseConfig = types.MergeBaseConfigs(config.CfRequest.BaseConfig, config.CallbackResponse.BaseConfig)
-
-	types.MergeHeaders(config.CfRequest.Headers, config.CallbackResponse.Headers)
-	return nil
-}
-
-func validateRequest(eventType types.EventType, request types.CfRequest, response types.CfRequest) error {
-	if response.Headers != nil {
-		if err := types.CheckHeaders(eventType, *request.Headers, *response.Headers); err != nil {
-			return err
-		}
+	// a generated response leaves the request as it was
+	if config.Returned && config.CallbackResponse.Status == nil {
+		types.ReplaceRequest(config.CfRequest, config.CallbackResponse.BaseConfig)
 	}
-
+	types.ReplaceRequestBody(config.CfRequest, config.CallbackResponse.RequestBody)
 	return nil
 }
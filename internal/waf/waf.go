@@ -0,0 +1,284 @@
+package waf
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edwardofclt/cloudfront-emulator/internal/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// DefaultRateLimitWindow is the evaluation window of rate-based rules when the
+// rule doesn't set one
+const DefaultRateLimitWindow = 5 * time.Minute
+
+// WebACL evaluates the rules of a web ACL, it keeps track of the requests
+// rate-based rules have seen
+type WebACL struct {
+	config types.WebACL
+	rules  []*rule
+}
+
+type rule struct {
+	types.WAFRule
+	networks []*net.IPNet
+	word     *regexp.Regexp
+
+	mu       sync.Mutex
+	requests map[string][]time.Time
+	swept    time.Time
+}
+
+// Result is the outcome of the evaluation of a request
+type Result struct {
+	// Action is allow or block
+	Action string
+	// Rule is the name of the rule that allowed or blocked the request, it's
+	// empty when the default action was taken
+	Rule string
+	// Counted are the names of the count rules that matched
+	Counted []string
+	// BlockResponse is the custom response of a blocked request
+	BlockResponse *types.WAFResponse
+}
+
+// Load reads the web ACL from a YAML or JSON rules file
+func Load(file string) (*WebACL, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read the web ACL %s", file)
+	}
+
+	config := types.WebACL{}
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the web ACL %s", file)
+	}
+
+	return New(config)
+}
+
+// New validates the web ACL
+func New(config types.WebACL) (*WebACL, error) {
+	switch config.DefaultAction {
+	case "":
+		config.DefaultAction = types.WAFActionAllow
+	case types.WAFActionAllow, types.WAFActionBlock:
+	default:
+		return nil, fmt.Errorf("the default action must be allow or block, got %s", config.DefaultAction)
+	}
+
+	acl := &WebACL{config: config}
+	for _, r := range config.Rules {
+		compiled, err := newRule(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rule %s", r.Name)
+		}
+		acl.rules = append(acl.rules, compiled)
+	}
+	return acl, nil
+}
+
+func newRule(config types.WAFRule) (*rule, error) {
+	r := &rule{WAFRule: config, requests: map[string][]time.Time{}}
+
+	switch r.Action {
+	case types.WAFActionAllow, types.WAFActionBlock, types.WAFActionCount:
+	default:
+		return nil, fmt.Errorf("the action must be allow, block or count, got %s", r.Action)
+	}
+
+	for _, address := range r.IPSet {
+		if !strings.Contains(address, "/") {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+				address += "/32"
+			} else {
+				address += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip set address: %s", address)
+		}
+		r.networks = append(r.networks, network)
+	}
+
+	if match := r.StringMatch; match != nil {
+		if match.Field != "uri" && match.Field != "querystring" && match.Field != "method" && !strings.HasPrefix(match.Field, "header:") {
+			return nil, fmt.Errorf("the string match field must be uri, querystring, method or header:<name>, got %s", match.Field)
+		}
+
+		switch match.PositionalConstraint {
+		case "":
+			match.PositionalConstraint = types.WAFConstraintContains
+		case types.WAFConstraintContainsWord:
+			r.word = regexp.MustCompile(`(^|[^a-zA-Z0-9_])` + regexp.QuoteMeta(match.SearchString) + `($|[^a-zA-Z0-9_])`)
+		case types.WAFConstraintExactly, types.WAFConstraintStartsWith, types.WAFConstraintEndsWith, types.WAFConstraintContains:
+		default:
+			return nil, fmt.Errorf("unknown positional constraint: %s", match.PositionalConstraint)
+		}
+
+		for _, transformation := range match.TextTransformations {
+			if transformation != types.WAFTransformationLowercase && transformation != types.WAFTransformationURLDecode {
+				return nil, fmt.Errorf("unknown text transformation: %s", transformation)
+			}
+		}
+	}
+
+	if r.RateLimit != nil && r.RateLimit.Window == 0 {
+		r.RateLimit.Window = DefaultRateLimitWindow
+	}
+
+	return r, nil
+}
+
+// Evaluate runs the rules against the request as the viewer sent it, until one
+// of them allows or blocks it
+func (acl *WebACL) Evaluate(r *http.Request, clientIP string, country string, now time.Time) Result {
+	result := Result{Action: acl.config.DefaultAction}
+	for _, rule := range acl.rules {
+		if !rule.matches(r, clientIP, country, now) {
+			continue
+		}
+
+		if rule.Action == types.WAFActionCount {
+			result.Counted = append(result.Counted, rule.Name)
+			continue
+		}
+
+		result.Action = rule.Action
+		result.Rule = rule.Name
+		if rule.Action == types.WAFActionBlock {
+			result.BlockResponse = rule.BlockResponse
+		}
+		break
+	}
+
+	if result.Action == types.WAFActionBlock && result.BlockResponse == nil {
+		result.BlockResponse = acl.config.BlockResponse
+	}
+	return result
+}
+
+func (r *rule) matches(req *http.Request, clientIP string, country string, now time.Time) bool {
+	if len(r.networks) > 0 {
+		ip := net.ParseIP(clientIP)
+		matched := false
+		for _, network := range r.networks {
+			matched = matched || (ip != nil && network.Contains(ip))
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.GeoMatch) > 0 {
+		matched := false
+		for _, location := range r.GeoMatch {
+			matched = matched || (country != "" && strings.EqualFold(location, country))
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if r.StringMatch != nil && !r.matchString(req) {
+		return false
+	}
+
+	// the other statements scope down the requests that are counted
+	if r.RateLimit != nil {
+		return r.exceedsRateLimit(clientIP, now)
+	}
+
+	return true
+}
+
+func (r *rule) matchString(req *http.Request) bool {
+	match := r.StringMatch
+
+	var value string
+	switch {
+	case match.Field == "uri":
+		value = req.URL.EscapedPath()
+	case match.Field == "querystring":
+		value = req.URL.RawQuery
+	case match.Field == "method":
+		value = req.Method
+	default:
+		name := strings.TrimPrefix(match.Field, "header:")
+		if strings.EqualFold(name, "host") {
+			value = req.Host
+		} else {
+			value = strings.Join(req.Header.Values(name), ", ")
+		}
+	}
+
+	for _, transformation := range match.TextTransformations {
+		switch transformation {
+		case types.WAFTransformationLowercase:
+			value = strings.ToLower(value)
+		case types.WAFTransformationURLDecode:
+			if decoded, err := url.PathUnescape(value); err == nil {
+				value = decoded
+			}
+		}
+	}
+
+	switch match.PositionalConstraint {
+	case types.WAFConstraintExactly:
+		return value == match.SearchString
+	case types.WAFConstraintStartsWith:
+		return strings.HasPrefix(value, match.SearchString)
+	case types.WAFConstraintEndsWith:
+		return strings.HasSuffix(value, match.SearchString)
+	case types.WAFConstraintContainsWord:
+		return r.word.MatchString(value)
+	}
+	return strings.Contains(value, match.SearchString)
+}
+
+// exceedsRateLimit records the request of the client and reports whether it
+// sent more requests than the limit within the window
+func (r *rule) exceedsRateLimit(clientIP string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	since := now.Add(-r.RateLimit.Window)
+	requests := recentRequests(r.requests[clientIP], since)
+	requests = append(requests, now)
+	r.requests[clientIP] = requests
+
+	// the clients that stopped sending requests are forgotten once per window
+	if now.Sub(r.swept) >= r.RateLimit.Window {
+		r.swept = now
+		for ip, times := range r.requests {
+			if recent := recentRequests(times, since); len(recent) > 0 {
+				r.requests[ip] = recent
+			} else {
+				delete(r.requests, ip)
+			}
+		}
+	}
+
+	return len(requests) > r.RateLimit.Limit
+}
+
+// recentRequests returns the requests that were sent after since
+func recentRequests(requests []time.Time, since time.Time) []time.Time {
+	recent := []time.Time{}
+	for _, t := range requests {
+		if t.After(since) {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}